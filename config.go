@@ -0,0 +1,424 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath is used when no -config flag is passed on the command line.
+const defaultConfigPath = "pingo.yaml"
+
+// defaultEnvPath is the optional .env file read when no -env flag is passed on the command line.
+const defaultEnvPath = ".env"
+
+// SSHTarget is the device pingo logs into to restart a tunnel, or a jump host on the way to it.
+// In YAML it can also be written as [user@]host[:port], like an OpenSSH ProxyJump entry.
+type SSHTarget struct {
+	Host         string      `yaml:"host"`         // Defaults to the tunnel's dev address
+	Port         int         `yaml:"port"`         // Defaults to 22
+	User         string      `yaml:"user"`         // Defaults to deviceTty.user
+	Cred         string      `yaml:"cred"`         // Defaults to deviceTty.cred
+	HostKeys     string      `yaml:"hostKeys"`     // strict or tofu, defaults to deviceTty.hostKeys
+	Fingerprints []string    `yaml:"fingerprints"` // SHA256 host key fingerprints; if set, the device must offer one of these and known_hosts is not used
+	Auth         []SSHAuth   `yaml:"auth"`         // Login methods in the order they are tried, defaults to deviceTty.auth
+	Jump         []SSHTarget `yaml:"jump"`         // Device only: jump hosts to go through, in order, like OpenSSH ProxyJump
+}
+
+// TunnelConfig describes one site-to-site tunnel and the addresses pingo walks through when it is down.
+type TunnelConfig struct {
+	Name        string             `yaml:"name"`
+	Tun         ProbeConfig        `yaml:"tun"`     // This is the tunnel we're monitoring
+	Wan         ProbeConfig        `yaml:"wan"`     // This is the WAN address we're using to check connectivity
+	Dev         ProbeConfig        `yaml:"dev"`     // This is where you'll SSH into if the tunnel is down
+	Company     int                `yaml:"company"` // Shorthand for ticket.company
+	Ticket      TunnelTicketConfig `yaml:"ticket"`  // Overrides the top-level ticket fields for this tunnel
+	SSH         SSHTarget          `yaml:"ssh"`
+	Remediation RemediationConfig  `yaml:"remediation"` // How to restart the tunnel on the device, defaults to ipsec restart
+	Playbook    []PlaybookStep     `yaml:"playbook"`    // Remediation steps tried in order, defaults to the top-level playbook
+	MaxRestarts int                `yaml:"maxRestarts"` // Defaults to the top-level maxRestarts
+	Health      HealthConfig       `yaml:"health"`      // Unset thresholds fall back to the top-level health section
+	Recovery    RecoveryConfig     `yaml:"recovery"`    // Unset fields fall back to the top-level recovery section
+	Ticketer    string             `yaml:"ticketer"`    // Name of the ticketing backend, defaults to the top-level ticketer
+
+	tickets map[string]TicketFields // Resolved ticket fields per stage, filled in by applyDefaults
+}
+
+// ManageConfig holds the ConnectWise Manage API location and credentials.
+type ManageConfig struct {
+	Site       string `yaml:"site"`       // e.g. na.myconnectwise.net, or a full URL to use something other than https
+	Release    string `yaml:"release"`    // e.g. v4_6_release
+	APIVersion string `yaml:"apiVersion"` // Defaults to 3.0
+	ClientID   string `yaml:"clientId"`   // ConnectWise developer clientId header
+	User       string `yaml:"user"`       // Company ID used to log in to Manage
+	PubKey     string `yaml:"pubKey"`
+	PrvKey     string `yaml:"prvKey"`
+
+	Timeout          time.Duration `yaml:"timeout"`          // Per-request timeout, defaults to 30s
+	MaxRetries       int           `yaml:"maxRetries"`       // Retries for 429, 5xx and network errors, defaults to 3
+	BreakerThreshold int           `yaml:"breakerThreshold"` // Consecutive failed calls before pingo stops calling Manage for a while, defaults to 5
+	BreakerCooldown  time.Duration `yaml:"breakerCooldown"`  // How long to stop calling Manage once the breaker opens, defaults to 1m
+}
+
+// TtyConfig holds the default credentials used to SSH into a device when its tunnel needs a restart.
+type TtyConfig struct {
+	User     string    `yaml:"user"`
+	Cred     string    `yaml:"cred"`
+	HostKeys string    `yaml:"hostKeys"` // How devices without pinned fingerprints are checked: strict (default) or tofu
+	Auth     []SSHAuth `yaml:"auth"`     // Login methods in the order they are tried, defaults to password then keyboard-interactive with cred
+}
+
+// TicketConfig holds the fields used when opening a new service ticket, along with how pingo finds and closes them.
+// Every tunnel resolves its own set of fields per stage from these; see TicketFields.
+type TicketConfig struct {
+	TicketFields     `yaml:",inline"`
+	Stages           map[string]TicketFields `yaml:"stages"`           // Per failure stage: tunnel, wan, device, degraded or flapping
+	DegradedSummary  string                  `yaml:"degradedSummary"`  // Shorthand for stages.degraded.summary
+	DegradedPriority int                     `yaml:"degradedPriority"` // Shorthand for stages.degraded.priority
+	Notes            NoteConfig              `yaml:"notes"`
+
+	ClosedStatuses []ClosedStatusSet `yaml:"closedStatuses"` // Statuses that count as closed even though Manage's closedFlag is false
+	Dedupe         DedupeConfig      `yaml:"dedupe"`
+}
+
+// DedupeConfig controls how pingo searches Manage for an open ticket to adopt before it creates one.
+// The search is limited to the tunnel's company and matches on a custom field tag and on summary.
+type DedupeConfig struct {
+	Enabled      bool              `yaml:"enabled"`      // Defaults to true
+	MatchSummary bool              `yaml:"matchSummary"` // Adopt a ticket whose summary starts with the one pingo renders for it, defaults to true
+	CustomField  CustomFieldConfig `yaml:"customField"`  // Tags every ticket pingo opens with the tunnel name
+}
+
+// CustomFieldConfig identifies a ticket custom field. Manage sets custom fields by ID but searches them by caption.
+type CustomFieldConfig struct {
+	ID      int    `yaml:"id"`
+	Caption string `yaml:"caption"`
+}
+
+// ClosedStatusSet lists the statuses on one board that pingo treats as closed, by ID or by name.
+type ClosedStatusSet struct {
+	Board int      `yaml:"board"`
+	IDs   []int    `yaml:"ids"`
+	Names []string `yaml:"names"` // Matched case-insensitively
+}
+
+// NoteConfig holds the ConnectWise flags set on every note pingo adds to a ticket.
+type NoteConfig struct {
+	Internal   bool `yaml:"internal"`   // Internal analysis, hidden from the customer
+	Detail     bool `yaml:"detail"`     // Detail description, visible to the customer
+	Resolution bool `yaml:"resolution"` // Resolution
+}
+
+// Config is the runtime configuration loaded from the config file at startup.
+type Config struct {
+	Interval       time.Duration             `yaml:"interval"`       // Time between checks in `pingo run`
+	ICMPMode       string                    `yaml:"icmpMode"`       // Default ICMP mode for every icmp probe: auto, privileged or unprivileged
+	StateFile      string                    `yaml:"stateFile"`      // Where incident state is kept between checks; changing it needs a restart
+	OutboxFile     string                    `yaml:"outboxFile"`     // Where ticket operations wait until the ticketer accepts them; changing it needs a restart
+	KnownHostsFile string                    `yaml:"knownHostsFile"` // Device host keys in OpenSSH known_hosts format
+	MaxRestarts    int                       `yaml:"maxRestarts"`    // Restart attempts per incident before pingo leaves the tunnel to a technician
+	Manage         ManageConfig              `yaml:"manage"`
+	DeviceTty      TtyConfig                 `yaml:"deviceTty"`
+	Ticket         TicketConfig              `yaml:"ticket"`
+	Health         HealthConfig              `yaml:"health"`
+	Recovery       RecoveryConfig            `yaml:"recovery"`
+	Playbook       []PlaybookStep            `yaml:"playbook"`  // Default remediation steps for tunnels without their own, defaults to a single bounce
+	Ticketer       string                    `yaml:"ticketer"`  // Default ticketing backend: connectwise or a name under ticketers
+	Ticketers      map[string]TicketerConfig `yaml:"ticketers"` // Jira, ServiceNow and webhook backends by name
+	Notifiers      map[string]NotifierConfig `yaml:"notifiers"` // Slack, Teams and webhook channels alerted on state changes, by name
+	Tunnels        []TunnelConfig            `yaml:"tunnels"`
+}
+
+// LoadConfig reads the YAML config file at path, fills in defaults and validates it.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	cfg := &Config{
+		Interval:       30 * time.Second,
+		ICMPMode:       ICMPAuto,
+		StateFile:      "pingo-state.json",
+		OutboxFile:     "pingo-outbox.json",
+		KnownHostsFile: "pingo_known_hosts",
+		MaxRestarts:    3,
+		Ticketer:       TicketerConnectWise,
+		Manage: ManageConfig{
+			Site:    "na.myconnectwise.net",
+			Release: "v4_6_release",
+		},
+		DeviceTty: TtyConfig{HostKeys: HostKeysStrict},
+		Ticket: TicketConfig{
+			TicketFields:    TicketFields{Summary: "SCRIPT TICKET - VPN Tunnel Down"},
+			DegradedSummary: "SCRIPT TICKET - VPN Tunnel Degraded",
+			Notes:           NoteConfig{Internal: true},
+			Dedupe:          DedupeConfig{Enabled: true, MatchSummary: true},
+		},
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	cfg.applyEnv()
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadEnvFile loads PINGO_* variables from the .env file at path into the process environment.
+// Variables already set in the environment are left alone, and a missing file is not an error.
+func LoadEnvFile(path string) error {
+	err := godotenv.Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// applyEnv overrides secrets from the config file with any PINGO_* environment variables that are set.
+// Call LoadEnvFile first so that values from .env are picked up too.
+func (c *Config) applyEnv() {
+	override := func(dst *string, key string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	override(&c.Manage.ClientID, "PINGO_MANAGE_CLIENT_ID")
+	override(&c.Manage.User, "PINGO_MANAGE_USER")
+	override(&c.Manage.PubKey, "PINGO_MANAGE_PUB_KEY")
+	override(&c.Manage.PrvKey, "PINGO_MANAGE_PRV_KEY")
+	override(&c.DeviceTty.User, "PINGO_TTY_USER")
+	override(&c.DeviceTty.Cred, "PINGO_TTY_CRED")
+	c.DeviceTty.Auth = envAuth(c.DeviceTty.Auth, defaultSSHAuth, "PINGO_TTY")
+	ttyAuth := c.DeviceTty.Auth
+	if len(ttyAuth) == 0 {
+		ttyAuth = defaultSSHAuth
+	}
+	for i := range c.Tunnels {
+		t := &c.Tunnels[i]
+		key := "PINGO_TUNNEL_" + envName(t.Name) + "_SSH"
+		override(&t.SSH.User, key+"_USER")
+		override(&t.SSH.Cred, key+"_CRED")
+		t.SSH.Auth = envAuth(t.SSH.Auth, ttyAuth, key)
+		for j := range t.SSH.Jump {
+			hop := &t.SSH.Jump[j]
+			key := fmt.Sprintf("%s_JUMP_%d", key, j)
+			override(&hop.User, key+"_USER")
+			override(&hop.Cred, key+"_CRED")
+			hop.Auth = envAuth(hop.Auth, jumpAuth(ttyAuth, hop.Cred), key)
+		}
+	}
+	for name, t := range c.Ticketers {
+		key := "PINGO_TICKETER_" + envName(name) + "_TOKEN"
+		override(&t.Jira.Token, key)
+		override(&t.ServiceNow.Password, key)
+		override(&t.Webhook.Token, key)
+		c.Ticketers[name] = t
+	}
+	for name, n := range c.Notifiers {
+		override(&n.URL, "PINGO_NOTIFIER_"+envName(name)+"_URL")
+		c.Notifiers[name] = n
+	}
+}
+
+// envAuth sets the passphrase of every key method in auth from <prefix>_PASSPHRASE and the password of every
+// password and keyboard-interactive method from <prefix>_PASSWORD. If either is set, auth is copied first, from
+// inherit if it is empty, so the lists it shares with other targets are left alone.
+func envAuth(auth, inherit []SSHAuth, prefix string) []SSHAuth {
+	passphrase, setPassphrase := os.LookupEnv(prefix + "_PASSPHRASE")
+	password, setPassword := os.LookupEnv(prefix + "_PASSWORD")
+	if !setPassphrase && !setPassword {
+		return auth
+	}
+	if len(auth) == 0 {
+		auth = inherit
+	}
+	auth = slices.Clone(auth)
+	for i := range auth {
+		switch {
+		case auth[i].Method == AuthKey && setPassphrase:
+			auth[i].Passphrase = passphrase
+		case (auth[i].Method == AuthPassword || auth[i].Method == AuthKeyboardInteractive) && setPassword:
+			auth[i].Password = password
+		}
+	}
+	return auth
+}
+
+// envName converts a ticketer, notifier or tunnel name to the form used in environment variable names.
+func envName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// applyDefaults fills in per-tunnel settings that fall back to the top-level config.
+func (c *Config) applyDefaults() {
+	base := c.Ticket.TicketFields
+	if base.Description == "" {
+		base.Description = defaultDescription
+	}
+	stages := make(map[string]TicketFields)
+	for _, st := range ticketStages {
+		stages[st] = base
+	}
+	stages[StageDegraded] = base.merge(TicketFields{Summary: c.Ticket.DegradedSummary, Priority: c.Ticket.DegradedPriority})
+	for st, f := range c.Ticket.Stages {
+		stages[st] = stages[st].merge(f)
+	}
+	c.Health = c.Health.withDefaults(defaultHealthConfig)
+	c.Recovery = c.Recovery.withDefaults(defaultRecoveryConfig)
+	if len(c.DeviceTty.Auth) == 0 {
+		c.DeviceTty.Auth = defaultSSHAuth
+	}
+	if len(c.Playbook) == 0 {
+		c.Playbook = defaultPlaybook
+	}
+	for i := range c.Tunnels {
+		t := &c.Tunnels[i]
+		t.Health = t.Health.withDefaults(c.Health)
+		t.Recovery = t.Recovery.withDefaults(c.Recovery)
+		t.Tun = t.Tun.withDefaults(c.ICMPMode)
+		t.Wan = t.Wan.withDefaults(c.ICMPMode)
+		t.Dev = t.Dev.withDefaults(c.ICMPMode)
+		t.tickets = make(map[string]TicketFields)
+		tunnel := TicketFields{Company: t.Company}.merge(t.Ticket.TicketFields)
+		for _, st := range ticketStages {
+			t.tickets[st] = stages[st].merge(tunnel).merge(t.Ticket.Stages[st])
+		}
+		if t.MaxRestarts == 0 {
+			t.MaxRestarts = c.MaxRestarts
+		}
+		if t.Ticketer == "" {
+			t.Ticketer = c.Ticketer
+		}
+		if t.SSH.Host == "" {
+			t.SSH.Host = t.Dev.Address
+		}
+		t.SSH = t.SSH.withDefaults(c.DeviceTty)
+		if t.Remediation.Driver == "" {
+			t.Remediation.Driver = DriverIPsec
+		}
+		for j := range t.SSH.Jump {
+			t.SSH.Jump[j] = t.SSH.Jump[j].jumpDefaults(c.DeviceTty)
+		}
+		if len(t.Playbook) == 0 {
+			t.Playbook = c.Playbook
+		}
+		steps := make([]PlaybookStep, len(t.Playbook))
+		for j, st := range t.Playbook {
+			steps[j] = st.withDefaults(t.Tun, c.ICMPMode)
+		}
+		t.Playbook = steps
+	}
+}
+
+// Validate checks that every field pingo needs to run is present.
+func (c *Config) Validate() error {
+	var errs []error
+	require := func(v, name string) {
+		if v == "" {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+	if c.usesManage() {
+		require(c.Manage.Site, "manage.site")
+		require(c.Manage.Release, "manage.release")
+		require(c.Manage.ClientID, "manage.clientId")
+		require(c.Manage.User, "manage.user")
+		require(c.Manage.PubKey, "manage.pubKey")
+		require(c.Manage.PrvKey, "manage.prvKey")
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Ticketers)) {
+		if name == TicketerConnectWise {
+			errs = append(errs, fmt.Errorf("ticketers.%s: the name is reserved for the manage section", name))
+			continue
+		}
+		errs = append(errs, c.Ticketers[name].validate("ticketers."+name))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Notifiers)) {
+		errs = append(errs, c.Notifiers[name].validate("notifiers."+name))
+	}
+	if err := validateICMPMode(c.ICMPMode); err != nil {
+		errs = append(errs, err)
+	}
+	require(c.StateFile, "stateFile")
+	require(c.OutboxFile, "outboxFile")
+	require(c.KnownHostsFile, "knownHostsFile")
+	if c.MaxRestarts < 1 {
+		errs = append(errs, errors.New("maxRestarts must be positive"))
+	}
+	if c.Interval <= 0 {
+		errs = append(errs, errors.New("interval must be positive"))
+	}
+	errs = append(errs, checkStages("ticket.stages", c.Ticket.Stages))
+	if f := c.Ticket.Dedupe.CustomField; (f.ID == 0) != (f.Caption == "") {
+		errs = append(errs, errors.New("ticket.dedupe.customField needs both id and caption"))
+	}
+	for i, set := range c.Ticket.ClosedStatuses {
+		if set.Board == 0 {
+			errs = append(errs, fmt.Errorf("ticket.closedStatuses[%d].board is required", i))
+		}
+		if len(set.IDs) == 0 && len(set.Names) == 0 {
+			errs = append(errs, fmt.Errorf("ticket.closedStatuses[%d] needs at least one entry in ids or names", i))
+		}
+	}
+	if len(c.Tunnels) == 0 {
+		errs = append(errs, errors.New("at least one entry in tunnels is required"))
+	}
+	seen := make(map[string]bool)
+	for i, t := range c.Tunnels {
+		prefix := fmt.Sprintf("tunnels[%d]", i)
+		if t.Name != "" {
+			prefix = fmt.Sprintf("tunnels[%s]", t.Name)
+		}
+		require(t.Name, prefix+".name")
+		if seen[t.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate tunnel name", prefix))
+		}
+		seen[t.Name] = true
+		if strings.Contains(t.Name, "^") {
+			errs = append(errs, fmt.Errorf("%s.name must not contain ^, which separates ServiceNow query conditions", prefix))
+		}
+		errs = append(errs, t.Tun.validate(prefix+".tun"), t.Wan.validate(prefix+".wan"), t.Dev.validate(prefix+".dev"), t.Recovery.validate(prefix+".recovery", t.Ticketer == TicketerConnectWise))
+		errs = append(errs, t.SSH.validate(prefix+".ssh"), t.Remediation.validate(prefix+".remediation"))
+		for j, st := range t.Playbook {
+			errs = append(errs, st.validate(fmt.Sprintf("%s.playbook[%d]", prefix, j), t.Remediation))
+		}
+		for j, hop := range t.SSH.Jump {
+			errs = append(errs, hop.validate(fmt.Sprintf("%s.ssh.jump[%d]", prefix, j)))
+			if len(hop.Jump) > 0 {
+				errs = append(errs, fmt.Errorf("%s.ssh.jump[%d]: a jump host can't have its own jump hosts, list every hop under the device", prefix, j))
+			}
+		}
+		if _, ok := c.Ticketers[t.Ticketer]; !ok && t.Ticketer != TicketerConnectWise {
+			errs = append(errs, fmt.Errorf("%s.ticketer: no ticketer named %q", prefix, t.Ticketer))
+		}
+		errs = append(errs, checkStages(prefix+".ticket.stages", t.Ticket.Stages))
+		reported := make(map[string]bool)
+		for _, st := range ticketStages {
+			err := t.tickets[st].validate(fmt.Sprintf("%s.ticket (%s)", prefix, st), t.Ticketer == TicketerConnectWise)
+			if err != nil && !reported[err.Error()] {
+				reported[err.Error()] = true
+				errs = append(errs, err)
+			}
+		}
+		if h := t.Health; h.FailuresToDown < 1 || h.SuccessesToUp < 1 || h.FlapCount < 1 || h.FlapWindow <= 0 {
+			errs = append(errs, fmt.Errorf("%s.health: thresholds must be positive", prefix))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// usesManage reports whether any tunnel opens its tickets in ConnectWise Manage.
+func (c *Config) usesManage() bool {
+	return slices.ContainsFunc(c.Tunnels, func(t TunnelConfig) bool { return t.Ticketer == TicketerConnectWise })
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSSHTargetShortForm(t *testing.T) {
+	for in, want := range map[string]SSHTarget{
+		"bastion":             {Host: "bastion"},
+		"pingo@bastion:2200":  {User: "pingo", Host: "bastion", Port: 2200},
+		"pingo@[fe80::1]:22":  {User: "pingo", Host: "fe80::1", Port: 22},
+		"pingo@[fe80::1]":     {User: "pingo", Host: "fe80::1"},
+		"2001:db8::1":         {Host: "2001:db8::1"},
+		"jump@203.0.113.5:22": {User: "jump", Host: "203.0.113.5", Port: 22},
+	} {
+		var got SSHTarget
+		if err := yaml.Unmarshal([]byte(in), &got); err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		if got.User != want.User || got.Host != want.Host || got.Port != want.Port {
+			t.Errorf("%s = %+v, want %+v", in, got, want)
+		}
+	}
+}
+
+func TestApplyEnvSSHSecrets(t *testing.T) {
+	t.Setenv("PINGO_TTY_PASSPHRASE", "tty-phrase")
+	t.Setenv("PINGO_TUNNEL_ACME_HQ_SSH_CRED", "hq-cred")
+	t.Setenv("PINGO_TUNNEL_ACME_HQ_SSH_PASSWORD", "hq-password")
+	t.Setenv("PINGO_TUNNEL_ACME_HQ_SSH_JUMP_1_PASSPHRASE", "bastion-phrase")
+
+	key := SSHAuth{Method: AuthKey, KeyFile: "~/.ssh/pingo"}
+	c := &Config{
+		DeviceTty: TtyConfig{User: "root", Cred: "tty-cred", Auth: []SSHAuth{key, {Method: AuthPassword}}},
+		Tunnels: []TunnelConfig{
+			{Name: "acme-hq", SSH: SSHTarget{Jump: []SSHTarget{{Host: "a"}, {Host: "b", Auth: []SSHAuth{key}}}}},
+			{Name: "globex"},
+		},
+	}
+	c.applyEnv()
+	c.applyDefaults()
+
+	if got := c.DeviceTty.Auth[0].Passphrase; got != "tty-phrase" {
+		t.Errorf("deviceTty key passphrase = %q, want tty-phrase", got)
+	}
+	hq := c.Tunnels[0].SSH
+	if hq.Cred != "hq-cred" || hq.Auth[0].Passphrase != "tty-phrase" || hq.Auth[1].Password != "hq-password" {
+		t.Errorf("acme-hq ssh = cred %q, auth %+v, want its own cred and password on the inherited methods", hq.Cred, hq.Auth)
+	}
+	if got := hq.Jump[1].Auth[0].Passphrase; got != "bastion-phrase" {
+		t.Errorf("acme-hq jump 1 passphrase = %q, want bastion-phrase", got)
+	}
+	if hop := hq.Jump[0]; hop.Cred != "" || len(hop.Auth) != 1 || hop.Auth[0].Passphrase != "tty-phrase" {
+		t.Errorf("acme-hq jump 0 = cred %q, auth %+v, want only deviceTty's key method", hop.Cred, hop.Auth)
+	}
+	globex := c.Tunnels[1].SSH
+	if globex.Cred != "tty-cred" || globex.Auth[1].Password != "" {
+		t.Errorf("globex ssh = cred %q, auth %+v, want deviceTty's untouched", globex.Cred, globex.Auth)
+	}
+}
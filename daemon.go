@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is how long a shutdown or reload waits for running checks, such as a remediation playbook, before cancelling them.
+const shutdownGrace = 1 * time.Minute
+
+// runDaemon checks every configured tunnel on cfg.Interval until SIGINT or SIGTERM is received.
+// SIGHUP reloads the config file; sites keep their state across reloads as long as their name is unchanged.
+// The .env file is only read at startup, so new secrets must come from the config file or a restart.
+func runDaemon(configPath string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	sites := make(map[string]*Site)
+	AddtoLog(fmt.Sprintf("pingo daemon started with %d tunnels, checking every %s", len(cfg.Tunnels), cfg.Interval))
+	for {
+		// Scheduling stops as soon as a signal arrives, but a check that is already running, which may be halfway
+		// through a remediation playbook, is only cut short by abort.
+		runCtx, stopScheduling := context.WithCancel(ctx)
+		checkCtx, abort := context.WithCancel(context.Background())
+		outboxCtx, stopOutbox := context.WithCancel(context.Background())
+		outboxDone := make(chan struct{})
+		go func() {
+			defer close(outboxDone)
+			outbox.Run(outboxCtx, cfg.Interval)
+		}()
+		var wg sync.WaitGroup
+		for _, t := range cfg.Tunnels {
+			s, ok := sites[t.Name]
+			if ok {
+				s.Reconfigure(t)
+			} else {
+				s = NewSite(t)
+				sites[t.Name] = s
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.Run(runCtx, checkCtx, cfg.Interval)
+			}()
+		}
+		// finish lets the sites' running checks finish, aborting them after shutdownGrace,
+		// then stops the outbox once it has picked up what they queued.
+		finish := func() {
+			stopScheduling()
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(shutdownGrace):
+				AddtoLog(fmt.Sprintf("Running checks did not finish within %s. Cancelling them", shutdownGrace))
+				abort()
+				<-done
+			}
+			abort()
+			stopOutbox()
+			<-outboxDone
+		}
+
+		select {
+		case <-ctx.Done():
+			AddtoLog(fmt.Sprintf("Received shutdown signal. Waiting up to %s for running checks to finish...", shutdownGrace))
+			finish()
+			notifications.Wait()
+			AddtoLog("pingo daemon stopped")
+			return 0
+		case <-hup:
+			AddtoLog(fmt.Sprintf("Received SIGHUP. Waiting up to %s for running checks to finish before reloading %s", shutdownGrace, configPath))
+			finish()
+			if ctx.Err() != nil {
+				// A shutdown signal arrived while waiting, and the wait was already bounded by shutdownGrace
+				AddtoLog("Received shutdown signal during the reload")
+				notifications.Wait()
+				AddtoLog("pingo daemon stopped")
+				return 0
+			}
+			newCfg, err := LoadConfig(configPath)
+			if err != nil {
+				AddtoLog(fmt.Sprintf("Failed to reload config, keeping the previous one: %v", err))
+				continue
+			}
+			newCW := newManageClient(newCfg.Manage)
+			if err := verifyBoardStatuses(newCW, newCfg, boardCheckTimeout); err != nil {
+				AddtoLog(fmt.Sprintf("Ticket statuses do not match Manage, keeping the previous config: %v", err))
+				continue
+			}
+			cfg, ticketers, notifiers = newCfg, newTicketers(newCfg, newCW), newNotifiers(newCfg)
+			for name := range sites {
+				if !slices.ContainsFunc(cfg.Tunnels, func(t TunnelConfig) bool { return t.Name == name }) {
+					delete(sites, name)
+				}
+			}
+			AddtoLog(fmt.Sprintf("Config reloaded with %d tunnels", len(cfg.Tunnels)))
+		}
+	}
+}
+
+// checkOnce runs a single check of every configured tunnel concurrently and returns the highest code any site returned.
+// There is no history to apply hysteresis to, so a single failed check counts as down and a single passing one as up.
+// The outbox is flushed once the checks finish; anything the ticketer doesn't accept waits for the next run.
+func checkOnce() int {
+	codes := make([]int, len(cfg.Tunnels))
+	var wg sync.WaitGroup
+	for n, t := range cfg.Tunnels {
+		t.Health.FailuresToDown, t.Health.SuccessesToUp = 1, 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[n] = NewSite(t).Check(context.Background())
+		}()
+	}
+	wg.Wait()
+	outbox.Flush(context.Background())
+	notifications.Wait()
+	if n := outbox.Len(); n > 0 {
+		AddtoLog(fmt.Sprintf("%d ticket operations are still queued in %s", n, cfg.OutboxFile))
+	}
+	return slices.Max(codes)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// HealthState is where a tunnel sits in its health state machine.
+type HealthState int
+
+const (
+	StateUp         HealthState = iota // Probes are passing
+	StateDegraded                      // Probes have started failing but not for long enough to call the tunnel down, or loss, latency or jitter is over its threshold
+	StateDown                          // failuresToDown consecutive probes failed, remediation runs in this state
+	StateRecovering                    // Probes have started passing again but not for long enough to call the tunnel up
+	StateFlapping                      // The tunnel went down more than flapCount times in flapWindow, remediation is paused
+)
+
+func (h HealthState) String() string {
+	switch h {
+	case StateUp:
+		return "Up"
+	case StateDegraded:
+		return "Degraded"
+	case StateDown:
+		return "Down"
+	case StateRecovering:
+		return "Recovering"
+	case StateFlapping:
+		return "Flapping"
+	default:
+		return fmt.Sprintf("HealthState(%d)", int(h))
+	}
+}
+
+// parseHealthState is the inverse of HealthState.String. Unknown names are Up.
+func parseHealthState(name string) HealthState {
+	for h := StateUp; h <= StateFlapping; h++ {
+		if h.String() == name {
+			return h
+		}
+	}
+	return StateUp
+}
+
+// HealthConfig holds the hysteresis and flap detection thresholds for a tunnel.
+type HealthConfig struct {
+	FailuresToDown int           `yaml:"failuresToDown"` // Consecutive failed checks before the tunnel is Down
+	SuccessesToUp  int           `yaml:"successesToUp"`  // Consecutive passing checks before the tunnel is Up again
+	FlapCount      int           `yaml:"flapCount"`      // The tunnel is Flapping once it goes Down more than this many times...
+	FlapWindow     time.Duration `yaml:"flapWindow"`     // ...within this window
+}
+
+// defaultHealthConfig is used for any threshold left unset in the config file.
+var defaultHealthConfig = HealthConfig{
+	FailuresToDown: 3,
+	SuccessesToUp:  2,
+	FlapCount:      3,
+	FlapWindow:     time.Hour,
+}
+
+// withDefaults returns h with every unset threshold taken from def.
+func (h HealthConfig) withDefaults(def HealthConfig) HealthConfig {
+	if h.FailuresToDown == 0 {
+		h.FailuresToDown = def.FailuresToDown
+	}
+	if h.SuccessesToUp == 0 {
+		h.SuccessesToUp = def.SuccessesToUp
+	}
+	if h.FlapCount == 0 {
+		h.FlapCount = def.FlapCount
+	}
+	if h.FlapWindow == 0 {
+		h.FlapWindow = def.FlapWindow
+	}
+	return h
+}
+
+// Transition describes a change of HealthState and why it happened.
+type Transition struct {
+	From, To HealthState
+	Reason   string
+}
+
+func (t Transition) String() string {
+	return fmt.Sprintf("State %s -> %s: %s", t.From, t.To, t.Reason)
+}
+
+// Health tracks consecutive probe results for one tunnel and decides its HealthState.
+type Health struct {
+	Config    HealthConfig
+	State     HealthState
+	Since     time.Time   // When State was entered
+	Breach    string      // Why the last passing check was over its thresholds, "" if it wasn't
+	failures  int         // Consecutive failed checks
+	successes int         // Consecutive passing checks
+	Downs     []time.Time // When the tunnel went Down, pruned to FlapWindow and kept in the state store
+}
+
+// NewHealth returns a Health that starts out Up.
+func NewHealth(cfg HealthConfig, now time.Time) *Health {
+	return &Health{Config: cfg, State: StateUp, Since: now}
+}
+
+// Observe records the result of one check and returns the transition it caused, if any.
+// breach is why a passing check exceeded its loss, latency or jitter thresholds, or "" if it didn't.
+// A breach keeps the tunnel Degraded but never takes it Down.
+func (h *Health) Observe(up bool, breach string, now time.Time) (Transition, bool) {
+	h.Breach = ""
+	if up {
+		h.Breach = breach
+		h.successes++
+		h.failures = 0
+	} else {
+		h.failures++
+		h.successes = 0
+	}
+	h.pruneDowns(now)
+
+	switch h.State {
+	case StateUp, StateDegraded:
+		if up {
+			switch {
+			case breach != "" && h.State == StateUp:
+				return h.move(StateDegraded, now, breach)
+			case breach == "" && h.State == StateDegraded:
+				return h.move(StateUp, now, "check passed within thresholds")
+			}
+			return Transition{}, false
+		}
+		if h.failures >= h.Config.FailuresToDown {
+			return h.goDown(now)
+		}
+		if h.State == StateUp {
+			return h.move(StateDegraded, now, h.failureReason())
+		}
+
+	case StateDown, StateRecovering:
+		if !up {
+			if h.State == StateRecovering {
+				return h.move(StateDown, now, "check failed while recovering")
+			}
+			return Transition{}, false
+		}
+		if h.successes >= h.Config.SuccessesToUp {
+			if breach != "" {
+				return h.move(StateDegraded, now, h.successReason()+" but "+breach)
+			}
+			return h.move(StateUp, now, h.successReason())
+		}
+		if h.State == StateDown {
+			return h.move(StateRecovering, now, h.successReason())
+		}
+
+	case StateFlapping:
+		// Count would-be outages while flapping so the tunnel only leaves this state once it has settled.
+		if !up && h.failures == h.Config.FailuresToDown {
+			h.Downs = append(h.Downs, now)
+		}
+		if len(h.Downs) == 0 {
+			if up && h.successes >= h.Config.SuccessesToUp {
+				return h.move(StateUp, now, fmt.Sprintf("no outages in the last %s and %s", h.Config.FlapWindow, h.successReason()))
+			}
+			if !up && h.failures >= h.Config.FailuresToDown {
+				return h.move(StateDown, now, fmt.Sprintf("stopped flapping and has been down for %d consecutive checks", h.failures))
+			}
+		}
+	}
+	return Transition{}, false
+}
+
+// goDown moves the tunnel to Down, or to Flapping if it has gone down too often within FlapWindow.
+func (h *Health) goDown(now time.Time) (Transition, bool) {
+	h.Downs = append(h.Downs, now)
+	if len(h.Downs) > h.Config.FlapCount {
+		return h.move(StateFlapping, now, fmt.Sprintf("went down %d times in the last %s", len(h.Downs), h.Config.FlapWindow))
+	}
+	return h.move(StateDown, now, h.failureReason())
+}
+
+// pruneDowns forgets outages older than FlapWindow.
+func (h *Health) pruneDowns(now time.Time) {
+	i := 0
+	for i < len(h.Downs) && now.Sub(h.Downs[i]) > h.Config.FlapWindow {
+		i++
+	}
+	h.Downs = h.Downs[i:]
+}
+
+func (h *Health) move(to HealthState, now time.Time, reason string) (Transition, bool) {
+	t := Transition{From: h.State, To: to, Reason: reason}
+	h.State = to
+	h.Since = now
+	return t, true
+}
+
+func (h *Health) failureReason() string {
+	return fmt.Sprintf("%d/%d consecutive checks failed", h.failures, h.Config.FailuresToDown)
+}
+
+func (h *Health) successReason() string {
+	return fmt.Sprintf("%d/%d consecutive checks passed", h.successes, h.Config.SuccessesToUp)
+}
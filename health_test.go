@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// step is one check fed to Health.Observe, the gap since the previous one and the state the tunnel should be in after it.
+type step struct {
+	up     bool
+	breach string
+	gap    time.Duration // Defaults to a minute
+	want   HealthState
+}
+
+func pass(want HealthState) step { return step{up: true, want: want} }
+func fail(want HealthState) step { return step{want: want} }
+func slow(want HealthState) step { return step{up: true, breach: "avg RTT 300ms > 200ms", want: want} }
+
+// later returns s taking place gap after the previous check.
+func (s step) later(gap time.Duration) step {
+	s.gap = gap
+	return s
+}
+
+func TestHealthTransitions(t *testing.T) {
+	hysteresis := HealthConfig{FailuresToDown: 3, SuccessesToUp: 2, FlapCount: 3, FlapWindow: time.Hour}
+	flappy := HealthConfig{FailuresToDown: 1, SuccessesToUp: 1, FlapCount: 2, FlapWindow: time.Hour}
+	tests := []struct {
+		name  string
+		cfg   HealthConfig
+		steps []step
+	}{
+		{"stays up", hysteresis, []step{pass(StateUp), pass(StateUp)}},
+		{"a failure degrades", hysteresis, []step{fail(StateDegraded), pass(StateUp)}},
+		{"consecutive failures go down", hysteresis, []step{
+			fail(StateDegraded), fail(StateDegraded), fail(StateDown), fail(StateDown),
+		}},
+		{"a pass resets the failure count", hysteresis, []step{
+			fail(StateDegraded), fail(StateDegraded), pass(StateUp),
+			fail(StateDegraded), fail(StateDegraded), fail(StateDown),
+		}},
+		{"recovers through Recovering", hysteresis, []step{
+			fail(StateDegraded), fail(StateDegraded), fail(StateDown),
+			pass(StateRecovering), pass(StateUp),
+		}},
+		{"a failure while recovering goes back down", hysteresis, []step{
+			fail(StateDegraded), fail(StateDegraded), fail(StateDown),
+			pass(StateRecovering), fail(StateDown), pass(StateRecovering), pass(StateUp),
+		}},
+		{"a breach degrades but never takes the tunnel down", hysteresis, []step{
+			slow(StateDegraded), slow(StateDegraded), slow(StateDegraded), slow(StateDegraded), pass(StateUp),
+		}},
+		{"recovering with a breach lands in Degraded", hysteresis, []step{
+			fail(StateDegraded), fail(StateDegraded), fail(StateDown),
+			slow(StateRecovering), slow(StateDegraded), pass(StateUp),
+		}},
+		{"going down more than flapCount times flaps", flappy, []step{
+			fail(StateDown), pass(StateUp), fail(StateDown), pass(StateUp), fail(StateFlapping),
+			pass(StateFlapping), fail(StateFlapping), pass(StateFlapping),
+		}},
+		{"outages outside the window don't count", flappy, []step{
+			fail(StateDown), pass(StateUp), fail(StateDown), pass(StateUp),
+			fail(StateDown).later(2 * time.Hour), pass(StateUp), fail(StateDown), pass(StateUp),
+		}},
+		{"flapping settles once the window is quiet", flappy, []step{
+			fail(StateDown), pass(StateUp), fail(StateDown), pass(StateUp), fail(StateFlapping),
+			pass(StateFlapping).later(30 * time.Minute), pass(StateUp).later(time.Hour),
+		}},
+		{"flapping ends in Down if the tunnel stays down", flappy, []step{
+			fail(StateDown), pass(StateUp), fail(StateDown), pass(StateUp), fail(StateFlapping),
+			fail(StateFlapping).later(30 * time.Minute), fail(StateDown).later(time.Hour),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			h := NewHealth(tt.cfg, now)
+			for i, s := range tt.steps {
+				if s.gap == 0 {
+					s.gap = time.Minute
+				}
+				now = now.Add(s.gap)
+				from := h.State
+				tr, changed := h.Observe(s.up, s.breach, now)
+				if h.State != s.want {
+					t.Fatalf("check %d: %s -> %s, want %s", i+1, from, h.State, s.want)
+				}
+				if changed != (from != s.want) || changed && (tr.From != from || tr.To != s.want || tr.Reason == "") {
+					t.Errorf("check %d: transition %+v, changed %v, for %s -> %s", i+1, tr, changed, from, s.want)
+				}
+				if changed && h.Since != now {
+					t.Errorf("check %d: entered %s at %v, want %v", i+1, h.State, h.Since, now)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthDegradedBreachReason(t *testing.T) {
+	h := NewHealth(defaultHealthConfig, time.Now())
+	h.Observe(true, "loss 20% over 5%", time.Now())
+	if h.State != StateDegraded || h.Breach != "loss 20% over 5%" {
+		t.Fatalf("state %s, breach %q, want Degraded with the breach", h.State, h.Breach)
+	}
+	h.Observe(false, "", time.Now())
+	if h.Breach != "" {
+		t.Errorf("breach %q after a failed check, want none", h.Breach)
+	}
+}
+
+func TestParseHealthState(t *testing.T) {
+	for s := StateUp; s <= StateFlapping; s++ {
+		if got := parseHealthState(s.String()); got != s {
+			t.Errorf("parseHealthState(%q) = %s", s.String(), got)
+		}
+	}
+	if got := parseHealthState("Sideways"); got != StateUp {
+		t.Errorf("parseHealthState of an unknown name = %s, want Up", got)
+	}
+}
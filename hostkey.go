@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key policies for devices without pinned fingerprints.
+const (
+	HostKeysStrict = "strict" // The key must already be in the known_hosts file (default)
+	HostKeysTOFU   = "tofu"   // An unknown host's key is added to the known_hosts file on first contact
+)
+
+// ErrHostKeyUnknown is returned when a device's key is in neither its pinned fingerprints nor the known_hosts
+// file and trust on first use is off.
+var ErrHostKeyUnknown = errors.New("host key is not known")
+
+// HostKeyMismatchError is returned when a device offers a key other than the one pingo knows for it,
+// which may mean someone is impersonating the device. No credentials are sent.
+type HostKeyMismatchError struct {
+	Host string
+	Got  string   // SHA256 fingerprint of the key the device offered
+	Want []string // Fingerprints pinned in the config or recorded in known_hosts
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: got %s, want %s", e.Host, e.Got, strings.Join(e.Want, " or "))
+}
+
+// openSSHHostKeyAlgorithms is OpenSSH's host key preference, used for hosts with no known key so the key
+// pingo pins or checks against pinned fingerprints is the same one `ssh` would have shown.
+var openSSHHostKeyAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA,
+}
+
+// knownHostsMu serialises trust-on-first-use writes to the known_hosts file from concurrently running sites.
+var knownHostsMu sync.Mutex
+
+// validateFingerprint checks that fp looks like the output of ssh-keygen -l.
+func validateFingerprint(fp string) error {
+	if !strings.HasPrefix(fp, "SHA256:") || len(fp) == len("SHA256:") {
+		return fmt.Errorf("fingerprint %q must be a SHA256 fingerprint as printed by ssh-keygen -l, e.g. SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8", fp)
+	}
+	return nil
+}
+
+// hostKeyCallback returns the host key check for t. A device with pinned fingerprints must offer one of them.
+// Any other device is checked against the known_hosts file, and with the tofu policy an unknown device's
+// key is added to it. It also returns the host key algorithms to ask for, so a device that has keys of
+// several types offers the one pingo knows.
+func hostKeyCallback(logf func(string), t SSHTarget, hostport string) (ssh.HostKeyCallback, []string, error) {
+	if len(t.Fingerprints) > 0 {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fp := ssh.FingerprintSHA256(key)
+			if slices.Contains(t.Fingerprints, fp) {
+				return nil
+			}
+			return &HostKeyMismatchError{Host: hostname, Got: fp, Want: t.Fingerprints}
+		}, openSSHHostKeyAlgorithms, nil
+	}
+
+	path := cfg.KnownHostsFile
+	db, err := openKnownHosts(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	algos := knownAlgorithms(db, hostport)
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := db(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		fp := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) > 0 {
+			mismatch := &HostKeyMismatchError{Host: hostname, Got: fp}
+			for _, k := range keyErr.Want {
+				mismatch.Want = append(mismatch.Want, ssh.FingerprintSHA256(k.Key))
+			}
+			return mismatch
+		}
+		if t.HostKeys != HostKeysTOFU {
+			return fmt.Errorf("%w: %s offered %s %s, add it to %s or pin it under ssh.fingerprints", ErrHostKeyUnknown, hostname, key.Type(), fp, path)
+		}
+		if err := trustOnFirstUse(path, hostname, key); err != nil {
+			return err
+		}
+		logf(fmt.Sprintf("Trusting the %s key %s of %s on first use and adding it to %s", key.Type(), fp, hostname, path))
+		return nil
+	}, algos, nil
+}
+
+// openKnownHosts parses the known_hosts file at path. A missing file knows no hosts.
+func openKnownHosts(path string) (ssh.HostKeyCallback, error) {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return knownhosts.New(os.DevNull)
+	}
+	db, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// knownAlgorithms returns the host key algorithms that match the keys known for hostport, or OpenSSH's
+// preference if there are none.
+func knownAlgorithms(db ssh.HostKeyCallback, hostport string) []string {
+	var keyErr *knownhosts.KeyError
+	if err := db(hostport, &net.TCPAddr{}, probeKey{}); !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		return openSSHHostKeyAlgorithms
+	}
+	var algos []string
+	for _, k := range keyErr.Want {
+		typ := k.Key.Type()
+		if typ == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256) // Preferred over SHA-1 for the same key
+		}
+		if !slices.Contains(algos, typ) {
+			algos = append(algos, typ)
+		}
+	}
+	return algos
+}
+
+// probeKey is a key no host has, used to list the keys known_hosts holds for a host.
+type probeKey struct{}
+
+func (probeKey) Type() string                        { return "pingo-probe" }
+func (probeKey) Marshal() []byte                     { return []byte("pingo-probe") }
+func (probeKey) Verify([]byte, *ssh.Signature) error { return errors.New("probe key") }
+
+// trustOnFirstUse appends key for hostname to the known_hosts file at path, unless another site already did.
+func trustOnFirstUse(path, hostname string, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		db, err := knownhosts.New(path)
+		if err != nil {
+			return fmt.Errorf("reading known hosts %s: %w", path, err)
+		}
+		var keyErr *knownhosts.KeyError
+		switch err := db(hostname, &net.TCPAddr{}, key); {
+		case err == nil:
+			return nil
+		case errors.As(err, &keyErr) && len(keyErr.Want) > 0:
+			return fmt.Errorf("%s was added to %s with another key in the meantime", hostname, path)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("writing known hosts: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("writing known hosts: %w", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+		return fmt.Errorf("writing known hosts: %w", err)
+	}
+	return nil
+}
+
+// hostKeyMismatch alerts that the site's device offered an unexpected host key, once per incident and key.
+func (s *Site) hostKeyMismatch(e *HostKeyMismatchError) {
+	var seen bool
+	s.updateIncident(func(inc *Incident) {
+		seen = inc.HostKeyMismatch == e.Got
+		inc.HostKeyMismatch = e.Got
+	})
+	if !seen {
+		s.alert(EventHostKeyMismatch, fmt.Sprintf("Device %s offered host key %s instead of %s. pingo did not log in; check that the device hasn't been replaced or impersonated.",
+			e.Host, e.Got, strings.Join(e.Want, " or ")))
+	}
+}
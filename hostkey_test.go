@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshStandIn is an SSH server on a local port that accepts the password pw, records every command it is
+// asked to exec and answers it with exit status exit(cmd).
+type sshStandIn struct {
+	port int
+	mu   sync.Mutex
+	key  ssh.Signer // Host key offered to the next connection
+	cmds []string
+	exit func(cmd string) uint32
+}
+
+func newSSHStandIn(t *testing.T) *sshStandIn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &sshStandIn{port: l.Addr().(*net.TCPAddr).Port, key: newHostKey(t), exit: func(string) uint32 { return 0 }}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// newHostKey returns a fresh ed25519 host key.
+func newHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func (s *sshStandIn) serve(conn net.Conn) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, pw []byte) (*ssh.Permissions, error) {
+			if string(pw) != "pw" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	}
+	s.mu.Lock()
+	config.AddHostKey(s.key)
+	s.mu.Unlock()
+	sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range reqs {
+				var exec struct{ Command string }
+				if req.Type != "exec" || ssh.Unmarshal(req.Payload, &exec) != nil {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				s.mu.Lock()
+				s.cmds = append(s.cmds, exec.Command)
+				status := s.exit(exec.Command)
+				s.mu.Unlock()
+				ch.Write([]byte("ok\n"))
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+// target returns an SSHTarget that logs into the stand-in with the tofu policy.
+func (s *sshStandIn) target() SSHTarget {
+	return SSHTarget{Host: "127.0.0.1", Port: s.port, User: "pingo", HostKeys: HostKeysTOFU,
+		Auth: []SSHAuth{{Method: AuthPassword, Password: "pw"}}}
+}
+
+// setKey makes the stand-in offer key from the next connection on.
+func (s *sshStandIn) setKey(key ssh.Signer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+}
+
+// commands returns the commands run so far.
+func (s *sshStandIn) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+// dial logs into t and closes the connection again.
+func dial(t SSHTarget) error {
+	_, closeAll, err := dialSSH(func(string) {}, t)
+	if err == nil {
+		closeAll()
+	}
+	return err
+}
+
+func TestHostKeyTrustOnFirstUse(t *testing.T) {
+	known := filepath.Join(t.TempDir(), "known_hosts")
+	withConfig(t, &Config{KnownHostsFile: known})
+	srv := newSSHStandIn(t)
+	target := srv.target()
+
+	if err := dial(target); err != nil {
+		t.Fatalf("first contact: %v", err)
+	}
+	data, err := os.ReadFile(known)
+	if err != nil || !strings.Contains(string(data), "ssh-ed25519") {
+		t.Fatalf("known_hosts after first contact = %q, %v, want the device's key", data, err)
+	}
+	if err := dial(target); err != nil {
+		t.Fatalf("second contact with the same key: %v", err)
+	}
+	db, _ := openKnownHosts(known)
+	if algos := knownAlgorithms(db, target.addr()); len(algos) != 1 || algos[0] != ssh.KeyAlgoED25519 {
+		t.Errorf("host key algorithms for a known host = %v, want only the known key's", algos)
+	}
+
+	srv.setKey(newHostKey(t))
+	var mismatch *HostKeyMismatchError
+	if err := dial(target); !errors.As(err, &mismatch) || len(mismatch.Want) != 1 {
+		t.Errorf("contact with another key returned %v, want a HostKeyMismatchError", err)
+	}
+	if after, _ := os.ReadFile(known); string(after) != string(data) {
+		t.Errorf("known_hosts changed after a mismatch:\n%s", after)
+	}
+}
+
+func TestHostKeyStrict(t *testing.T) {
+	withConfig(t, &Config{KnownHostsFile: filepath.Join(t.TempDir(), "known_hosts")})
+	target := newSSHStandIn(t).target()
+	target.HostKeys = HostKeysStrict
+	if err := dial(target); !errors.Is(err, ErrHostKeyUnknown) {
+		t.Errorf("unknown host with the strict policy returned %v, want ErrHostKeyUnknown", err)
+	}
+	if _, err := os.Stat(cfg.KnownHostsFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("strict policy wrote known_hosts (%v)", err)
+	}
+}
+
+func TestHostKeyPinned(t *testing.T) {
+	withConfig(t, &Config{KnownHostsFile: filepath.Join(t.TempDir(), "known_hosts")})
+	srv := newSSHStandIn(t)
+	target := srv.target()
+	target.Fingerprints = []string{ssh.FingerprintSHA256(srv.key.PublicKey())}
+	if err := dial(target); err != nil {
+		t.Fatalf("pinned key: %v", err)
+	}
+	if _, err := os.Stat(cfg.KnownHostsFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a pinned device was added to known_hosts (%v)", err)
+	}
+
+	srv.setKey(newHostKey(t))
+	var mismatch *HostKeyMismatchError
+	if err := dial(target); !errors.As(err, &mismatch) || mismatch.Want[0] != target.Fingerprints[0] {
+		t.Errorf("unpinned key returned %v, want a HostKeyMismatchError naming the pinned fingerprint", err)
+	}
+	if err := validateFingerprint("nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"); err == nil {
+		t.Error("fingerprint without the SHA256: prefix passed validation")
+	}
+}
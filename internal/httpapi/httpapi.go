@@ -0,0 +1,145 @@
+// Package httpapi is the JSON-over-HTTP plumbing shared by the ticketing and notification clients.
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sentinel errors matched by Error.Is.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// Client sends JSON requests to one API. It is safe for concurrent use.
+type Client struct {
+	Name    string // Prefixes error messages, e.g. "jira"
+	BaseURL string
+	HTTP    *http.Client
+	Auth    func(*http.Request) // Adds credentials to every request
+}
+
+// New returns a Client for baseURL with the given per-request timeout, defaulting to 30s.
+func New(name, baseURL string, timeout time.Duration, auth func(*http.Request)) *Client {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	return &Client{Name: name, BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: &http.Client{Timeout: timeout}, Auth: auth}
+}
+
+// Do sends in as the JSON body of a request to path and decodes a 2xx JSON response into out, if out is not nil.
+// An out of type *[]byte receives the raw response body instead.
+// Requests that get no response return a *RequestError and non-2xx responses an *Error.
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("%s: encoding %s %s: %w", c.Name, method, path, err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("%s: %s %s: %w", c.Name, method, path, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Auth != nil {
+		c.Auth(req)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return &RequestError{Name: c.Name, Method: method, Path: path, Err: err}
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return &RequestError{Name: c.Name, Method: method, Path: path, Err: err}
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		b := strings.TrimSpace(string(data))
+		if len(b) > 512 {
+			b = b[:512]
+		}
+		return &Error{Name: c.Name, Method: method, Path: path, StatusCode: res.StatusCode, Header: res.Header, Body: b}
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = data
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s: decoding %s %s: %w", c.Name, method, path, err)
+	}
+	return nil
+}
+
+// RequestError is returned when a request never got a response, e.g. a DNS failure, refused connection or timeout.
+type RequestError struct {
+	Name   string
+	Method string
+	Path   string
+	Err    error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s: %s %s: %v", e.Name, e.Method, e.Path, e.Err)
+}
+
+func (e *RequestError) Unwrap() error { return e.Err }
+
+// Temporary reports true, since the API may well answer the next attempt.
+func (e *RequestError) Temporary() bool { return true }
+
+// Error is returned when the API answers with a non-2xx status.
+type Error struct {
+	Name       string
+	Method     string
+	Path       string
+	StatusCode int
+	Header     http.Header // e.g. Retry-After
+	Body       string
+}
+
+func (e *Error) Error() string {
+	s := fmt.Sprintf("%s: %s %s: %d %s", e.Name, e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Body != "" {
+		s += ": " + e.Body
+	}
+	return s
+}
+
+// Temporary reports whether the request may succeed if sent again: rate limiting and server errors.
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Is lets errors.Is match ErrNotFound and ErrUnauthorized by status code.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
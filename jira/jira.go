@@ -0,0 +1,144 @@
+// Package jira is a small client for the parts of the Jira Cloud REST API pingo opens tickets with.
+package jira
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"pingo/internal/httpapi"
+)
+
+// Errors matched by errors.Is against anything the client returns.
+var (
+	ErrNotFound     = httpapi.ErrNotFound
+	ErrUnauthorized = httpapi.ErrUnauthorized
+)
+
+// Config holds everything needed to talk to a Jira Cloud site.
+type Config struct {
+	URL     string // e.g. https://acme.atlassian.net
+	Email   string // Account the API token belongs to
+	Token   string
+	Timeout time.Duration // Per-request timeout, defaults to 30s
+}
+
+// Client calls the Jira REST API. It is safe for concurrent use.
+type Client struct {
+	api *httpapi.Client
+}
+
+// NewClient returns a Client for the site described by c.
+func NewClient(c Config) *Client {
+	return &Client{api: httpapi.New("jira", c.URL+"/rest/api/2", c.Timeout, func(r *http.Request) {
+		r.SetBasicAuth(c.Email, c.Token)
+	})}
+}
+
+// Ref identifies an object by ID, key or name; set whichever the field needs.
+type Ref struct {
+	ID   string `json:"id,omitempty"`
+	Key  string `json:"key,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// Fields are the issue fields pingo sets. Unset fields are left out of the request.
+type Fields struct {
+	Project     *Ref     `json:"project,omitempty"`
+	IssueType   *Ref     `json:"issuetype,omitempty"`
+	Summary     string   `json:"summary,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Priority    *Ref     `json:"priority,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+}
+
+// Issue is an issue as returned by GetIssue and Search.
+type Issue struct {
+	ID     string `json:"id"`
+	Key    string `json:"key"`
+	Fields struct {
+		Summary string `json:"summary"`
+		Status  struct {
+			Name           string `json:"name"`
+			StatusCategory struct {
+				Key string `json:"key"` // new, indeterminate or done
+			} `json:"statusCategory"`
+		} `json:"status"`
+	} `json:"fields"`
+}
+
+// Done reports whether the issue's status is in the done category.
+func (i *Issue) Done() bool { return i.Fields.Status.StatusCategory.Key == "done" }
+
+// Transition is a workflow transition available on an issue.
+type Transition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	To   struct {
+		Name string `json:"name"`
+	} `json:"to"`
+}
+
+// CreateIssue opens an issue and returns its key.
+func (c *Client) CreateIssue(ctx context.Context, f Fields) (string, error) {
+	var out Issue
+	if err := c.api.Do(ctx, http.MethodPost, "/issue", nil, map[string]any{"fields": f}, &out); err != nil {
+		return "", err
+	}
+	return out.Key, nil
+}
+
+// GetIssue fetches an issue's summary and status by key.
+func (c *Client) GetIssue(ctx context.Context, key string) (*Issue, error) {
+	q := url.Values{}
+	q.Set("fields", "summary,status")
+	var out Issue
+	if err := c.api.Do(ctx, http.MethodGet, "/issue/"+url.PathEscape(key), q, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateIssue sets the given fields on an issue.
+func (c *Client) UpdateIssue(ctx context.Context, key string, f Fields) error {
+	return c.api.Do(ctx, http.MethodPut, "/issue/"+url.PathEscape(key), nil, map[string]any{"fields": f}, nil)
+}
+
+// AddComment adds a plain-text comment to an issue.
+func (c *Client) AddComment(ctx context.Context, key, body string) error {
+	return c.api.Do(ctx, http.MethodPost, "/issue/"+url.PathEscape(key)+"/comment", nil, map[string]string{"body": body}, nil)
+}
+
+// Transitions lists the transitions available on an issue in its current status.
+func (c *Client) Transitions(ctx context.Context, key string) ([]Transition, error) {
+	var out struct {
+		Transitions []Transition `json:"transitions"`
+	}
+	if err := c.api.Do(ctx, http.MethodGet, "/issue/"+url.PathEscape(key)+"/transitions", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Transitions, nil
+}
+
+// DoTransition moves an issue through the transition with the given ID.
+func (c *Client) DoTransition(ctx context.Context, key, id string) error {
+	in := map[string]any{"transition": Ref{ID: id}}
+	return c.api.Do(ctx, http.MethodPost, "/issue/"+url.PathEscape(key)+"/transitions", nil, in, nil)
+}
+
+// Search returns up to max issues matching a JQL query.
+func (c *Client) Search(ctx context.Context, jql string, max int) ([]Issue, error) {
+	q := url.Values{}
+	q.Set("jql", jql)
+	q.Set("fields", "summary,status")
+	q.Set("maxResults", strconv.Itoa(max))
+	var out struct {
+		Issues []Issue `json:"issues"`
+	}
+	if err := c.api.Do(ctx, http.MethodGet, "/search/jql", q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Issues, nil
+}
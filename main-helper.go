@@ -1,305 +1,85 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"fmt"
-	"os"
 	"time"
 
-	"pingo/static"
+	"pingo/manage"
 )
 
-type ContactRef struct {
-	ID int `json:"id"`
-}
-type BoardRef struct {
-	ID int `json:"id"`
-}
-type StatusRef struct {
-	ID int `json:"id"`
-}
-type CompanyRef struct {
-	ID int `json:"id"`
-}
-type TypeRef struct {
-	ID int `json:"id"`
-}
-type SubTypeRef struct {
-	ID int `json:"id"`
-}
-type ItemRef struct {
-	ID int `json:"id"`
-}
-type PriorityRef struct {
-	ID int `json:"id"`
-}
-
-type PostTicket struct {
-	Summary    string      `json:"summary"`
-	RecordType string      `json:"recordType"`
-	Contact    ContactRef  `json:"contact"`
-	Board      BoardRef    `json:"board"`
-	Status     StatusRef   `json:"status"`
-	Company    CompanyRef  `json:"company"`
-	Type       TypeRef     `json:"type"`
-	SubType    SubTypeRef  `json:"subType"`
-	Item       ItemRef     `json:"item"`
-	Priority   PriorityRef `json:"priority"`
-}
-
-type Ticket struct {
-	ID         int    `json:"id"`
-	Summary    string `json:"summary"`
-	RecordType string `json:"recordType"`
-	Board      struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			BoardHref string `json:"board_href"`
-		} `json:"_info"`
-	} `json:"board"`
-	Status struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Sort int    `json:"Sort"`
-		Info struct {
-			StatusHref string `json:"status_href"`
-		} `json:"_info"`
-	} `json:"status"`
-	Company struct {
-		ID         int    `json:"id"`
-		Identifier string `json:"identifier"`
-		Name       string `json:"name"`
-		Info       struct {
-			CompanyHref string `json:"company_href"`
-			MobileGUID  string `json:"mobileGuid"`
-		} `json:"_info"`
-	} `json:"company"`
-	Site struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			SiteHref   string `json:"site_href"`
-			MobileGUID string `json:"mobileGuid"`
-		} `json:"_info"`
-	} `json:"site"`
-	SiteName        string `json:"siteName"`
-	AddressLine1    string `json:"addressLine1"`
-	AddressLine2    string `json:"addressLine2"`
-	City            string `json:"city"`
-	StateIdentifier string `json:"stateIdentifier"`
-	Zip             string `json:"zip"`
-	Country         struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			CountryHref string `json:"country_href"`
-		} `json:"_info"`
-	} `json:"country"`
-	ContactName string `json:"contactName"`
-	Type        struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			TypeHref string `json:"type_href"`
-		} `json:"_info"`
-	} `json:"type"`
-	SubType struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			SubTypeHref string `json:"subType_href"`
-		} `json:"_info"`
-	} `json:"subType"`
-	Team struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			TeamHref string `json:"team_href"`
-		} `json:"_info"`
-	} `json:"team"`
-	Priority struct {
-		ID    int    `json:"id"`
-		Name  string `json:"name"`
-		Sort  int    `json:"sort"`
-		Level string `json:"level"`
-		Info  struct {
-			PriorityHref string `json:"priority_href"`
-			ImageHref    string `json:"image_href"`
-		} `json:"_info"`
-	} `json:"priority"`
-	ServiceLocation struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			LocationHref string `json:"location_href"`
-		} `json:"_info"`
-	} `json:"serviceLocation"`
-	Source struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			SourceHref string `json:"source_href"`
-		} `json:"_info"`
-	} `json:"source"`
-	Severity                   string    `json:"severity"`
-	Impact                     string    `json:"impact"`
-	AllowAllClientsPortalView  bool      `json:"allowAllClientsPortalView"`
-	CustomerUpdatedFlag        bool      `json:"customerUpdatedFlag"`
-	AutomaticEmailContactFlag  bool      `json:"automaticEmailContactFlag"`
-	AutomaticEmailResourceFlag bool      `json:"automaticEmailResourceFlag"`
-	AutomaticEmailCcFlag       bool      `json:"automaticEmailCcFlag"`
-	ClosedFlag                 bool      `json:"closedFlag"`
-	Approved                   bool      `json:"approved"`
-	EstimatedExpenseCost       float64   `json:"estimatedExpenseCost"`
-	EstimatedExpenseRevenue    float64   `json:"estimatedExpenseRevenue"`
-	EstimatedProductCost       float64   `json:"estimatedProductCost"`
-	EstimatedProductRevenue    float64   `json:"estimatedProductRevenue"`
-	EstimatedTimeCost          float64   `json:"estimatedTimeCost"`
-	EstimatedTimeRevenue       float64   `json:"estimatedTimeRevenue"`
-	BillingMethod              string    `json:"billingMethod"`
-	SubBillingMethod           string    `json:"subBillingMethod"`
-	DateResplan                time.Time `json:"dateResplan"`
-	DateResponded              time.Time `json:"dateResponded"`
-	ResolveMinutes             int       `json:"resolveMinutes"`
-	ResPlanMinutes             int       `json:"resPlanMinutes"`
-	RespondMinutes             int       `json:"respondMinutes"`
-	IsInSLA                    bool      `json:"isInSla"`
-	HasChildTicket             bool      `json:"hasChildTicket"`
-	HasMergedChildTicketFlag   bool      `json:"hasMergedChildTicketFlag"`
-	BillTime                   string    `json:"billTime"`
-	BillExpenses               string    `json:"billExpenses"`
-	BillProducts               string    `json:"billProducts"`
-	Location                   struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			LocationHref string `json:"location_href"`
-		} `json:"_info"`
-	} `json:"location"`
-	Department struct {
-		ID         int    `json:"id"`
-		Identifier string `json:"identifier"`
-		Name       string `json:"name"`
-		Info       struct {
-			DepartmentHref string `json:"department_href"`
-		} `json:"_info"`
-	} `json:"department"`
-	MobileGUID string `json:"mobileGuid"`
-	SLA        struct {
-		ID   int    `json:"id"`
-		Name string `json:"name"`
-		Info struct {
-			SLAHref string `json:"sla_href"`
-		} `json:"_info"`
-	} `json:"sla"`
-	SLAStatus            string `json:"slaStatus"`
-	RequestForChangeFlag bool   `json:"requestForChangeFlag"`
-	Currency             struct {
-		ID                      int    `json:"id"`
-		Symbol                  string `json:"symbol"`
-		CurrencyCode            string `json:"currencyCode"`
-		DecimalSeparator        string `json:"decimalSeparator"`
-		NumberOfDecimals        int    `json:"numberOfDecimals"`
-		ThousandsSeparator      string `json:"thousandsSeparator"`
-		NegativeParenthesesFlag bool   `json:"negativeParenthesesFlag"`
-		DisplaySymbolFlag       bool   `json:"displaySymbolFlag"`
-		CurrencyIdentifier      string `json:"currencyIdentifier"`
-		DisplayIDFlag           bool   `json:"displayIdFlag"`
-		RightAlign              bool   `json:"rightAlign"`
-		Name                    string `json:"name"`
-		Info                    struct {
-			CurrencyHref string `json:"currency_href"`
-		} `json:"_info"`
-	} `json:"currency"`
-	Info struct {
-		LastUpdated         time.Time `json:"lastUpdated"`
-		UpdatedBy           string    `json:"updatedBy"`
-		DateEntered         time.Time `json:"dateEntered"`
-		EnteredBy           string    `json:"enteredBy"`
-		ActivitiesHref      string    `json:"activities_href"`
-		ScheduleentriesHref string    `json:"scheduleentries_href"`
-		DocumentsHref       string    `json:"documents_href"`
-		ConfigurationsHref  string    `json:"configurations_href"`
-		TasksHref           string    `json:"tasks_href"`
-		NotesHref           string    `json:"notes_href"`
-		ProductsHref        string    `json:"products_href"`
-		TimeentriesHref     string    `json:"timeentries_href"`
-		ExpenseEntriesHref  string    `json:"expenseEntries_href"`
-	} `json:"_info"`
-	EscalationStartDateUTC  time.Time `json:"escalationStartDateUTC"`
-	EscalationLevel         int       `json:"escalationLevel"`
-	MinutesBeforeWaiting    int       `json:"minutesBeforeWaiting"`
-	RespondedSkippedMinutes int       `json:"respondedSkippedMinutes"`
-	ResplanSkippedMinutes   int       `json:"resplanSkippedMinutes"`
-	RespondedHours          float64   `json:"respondedHours"`
-	RespondedBy             string    `json:"respondedBy"`
-	ResplanHours            float64   `json:"resplanHours"`
-	ResplanBy               string    `json:"resplanBy"`
-	ResolutionHours         float64   `json:"resolutionHours"`
-	MinutesWaiting          int       `json:"minutesWaiting"`
-	CustomFields            []struct {
-		ID               int    `json:"id"`
-		Caption          string `json:"caption"`
-		Type             string `json:"type"`
-		EntryMethod      string `json:"entryMethod"`
-		NumberOfDecimals int    `json:"numberOfDecimals"`
-		ConnectWiseID    string `json:"connectWiseId"`
-	} `json:"customFields"`
-}
-
-// ManageAuth generates a base64 encoded string for authentication
-func ManageAuth() string {
-	companyName := static.Manage.User
-	publicKey := static.Manage.PubKey
-	privateKey := static.Manage.PrvKey
-	combinedStr := companyName + "+" + publicKey + ":" + privateKey
-	base64Str := base64.StdEncoding.EncodeToString([]byte(combinedStr))
-	return base64Str
-}
-
-// PostTicketPayload generates a JSON payload for creating a new service ticket
-func PostTicketPayload() []byte {
-	var staticTicket = PostTicket{
-		RecordType: "ServiceTicket",
-		Contact:    ContactRef{ID: 1694}, // Bryan Pomares
-		Board:      BoardRef{ID: 1},      // Help Desk
-		Status:     StatusRef{ID: 579},   // Review by Dispatch
-		Type:       TypeRef{ID: 193},     // Break/Fix
-		SubType:    SubTypeRef{ID: 7},    // Network
-		Item:       ItemRef{ID: 57},      // Failure
-		Priority:   PriorityRef{ID: 6},   // Critical
+// PostTicketPayload generates the payload for creating a new ConnectWise service ticket from r
+// Only board and company are required, the other refs are left out when their ID is unset.
+// If ticket.dedupe.customField is set, the tunnel name is stored in it so later searches can find the ticket
+func PostTicketPayload(r TicketRequest) manage.PostTicket {
+	t := r.Fields
+	p := manage.PostTicket{
+		Summary:            r.Summary,
+		InitialDescription: r.Description,
+		RecordType:         "ServiceTicket",
+		Board:              manage.BoardRef{ID: t.Board},
+		Company:            manage.CompanyRef{ID: t.Company},
 	}
+	if t.Contact != 0 {
+		p.Contact = &manage.ContactRef{ID: t.Contact}
+	}
+	if t.Status != 0 {
+		p.Status = &manage.StatusRef{ID: t.Status}
+	}
+	if t.Type != 0 {
+		p.Type = &manage.TypeRef{ID: t.Type}
+	}
+	if t.SubType != 0 {
+		p.SubType = &manage.SubTypeRef{ID: t.SubType}
+	}
+	if t.Item != 0 {
+		p.Item = &manage.ItemRef{ID: t.Item}
+	}
+	if t.Priority != 0 {
+		p.Priority = &manage.PriorityRef{ID: t.Priority}
+	}
+	if f := cfg.Ticket.Dedupe.CustomField; f.ID != 0 {
+		p.CustomFields = []manage.CustomField{{ID: f.ID, Value: r.Site}}
+	}
+	return p
+}
 
-	payload := staticTicket
-	payload.Summary = "SCRIPT TICKET - TCT VPN Tunnel Down"
-	payload.Company = CompanyRef{ID: 19786} // TCT
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		return nil
+// ticketPatch returns the operations that move an existing ticket to the summary, type and priority of r.
+// Board, status and company are left alone, since a technician may already have moved the ticket.
+func ticketPatch(r TicketRequest) []manage.PatchOp {
+	p := r.Fields
+	ops := []manage.PatchOp{{Op: "replace", Path: "summary", Value: r.Summary}}
+	set := func(path string, id int) {
+		if id != 0 {
+			ops = append(ops, manage.PatchOp{Op: "replace", Path: path, Value: id})
+		}
 	}
-	return jsonData
+	set("type/id", p.Type) // Before subType and item, which must belong to it
+	set("subType/id", p.SubType)
+	set("item/id", p.Item)
+	set("priority/id", p.Priority)
+	return ops
 }
 
-// Checks if the device address is reachable before attempting to SSH into it
-func InitTtyToHost() {
-	if !TestAddress(devAddr, 2, 1*time.Second, 10*time.Second) {
-		AddtoLog(fmt.Sprintf("Device address %s is unresponsive before attempting to SSH", devAddr))
-		os.Exit(3)
+// InitTtyToHost checks if the device address is reachable before attempting to SSH into it and run the tunnel's playbook.
+// The check uses the device probe with a shorter count and timeout. A device behind jump hosts can't be reached
+// directly, so the first jump host's SSH port is checked instead. The outcome is noted on the incident's ticket.
+// It returns the exit code for the site, see runPlaybook.
+func (s *Site) InitTtyToHost(ctx context.Context) int {
+	pre := s.Dev
+	pre.Address = s.SSH.Host
+	if len(s.SSH.Jump) > 0 {
+		pre = ProbeConfig{Type: ProbeTCP, Address: s.SSH.Jump[0].Host, Port: s.SSH.Jump[0].Port}
+	}
+	pre.Count, pre.Interval, pre.Timeout = 2, 1*time.Second, 10*time.Second
+	if stats, err := s.test(ctx, pre.Prober()); err != nil {
+		return 6
+	} else if !stats.Up() {
+		s.Log(fmt.Sprintf("Device address %s is unresponsive before attempting to SSH", pre.Address))
+		s.addNote(fmt.Sprintf("Device %s did not respond, so the tunnel could not be restarted.", pre.Address))
+		s.alert(EventRemediationFailed, fmt.Sprintf("Device %s did not respond, so tunnel %s could not be restarted.", pre.Address, s.tun))
+		return 3
 	} else {
-		user := static.DeviceTty.User
-		cred := static.DeviceTty.Cred
-		AddtoLog(fmt.Sprintf("Attempting to Tunnel into: %s", devAddr))
-		if err := sshIntoHost(devAddr, user, cred, "ipsec restart"); err != nil {
-			AddtoLog(fmt.Sprintf("Failed to run command on device address %s: %v", devAddr, err))
-			os.Exit(4)
-		} else {
-			AddtoLog(fmt.Sprintf("Command ran successfully on device address %s", devAddr))
-			putTicketNote(0, "Tunnel was restarted successfully.")
-			os.Exit(0)
-		}
+		return s.runPlaybook(ctx)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,169 @@
+// Package manage is a small client for the ConnectWise Manage REST API.
+package manage
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"pingo/internal/httpapi"
+)
+
+// DefaultAPIVersion is the REST API version pingo is written against.
+const DefaultAPIVersion = "3.0"
+
+// Config holds everything needed to talk to a Manage site.
+type Config struct {
+	Site       string // Host name such as na.myconnectwise.net, or a full URL to override the https scheme
+	Release    string // Codebase such as v4_6_release
+	APIVersion string // Defaults to DefaultAPIVersion
+	ClientID   string // Developer clientId sent with every request
+	Company    string // Company ID used to log in
+	PublicKey  string
+	PrivateKey string
+	Timeout    time.Duration // Per-request timeout, defaults to 30s
+
+	MaxRetries       int           // Retries after the first attempt for temporary failures, defaults to 3. Negative disables retries.
+	BaseBackoff      time.Duration // First retry delay before jitter, doubled for each further retry, defaults to 500ms
+	MaxBackoff       time.Duration // Cap on the retry delay, defaults to 30s
+	BreakerThreshold int           // Consecutive failed calls that open the circuit breaker, defaults to 5
+	BreakerCooldown  time.Duration // How long the breaker stays open before a trial call, defaults to 1m
+}
+
+// Client calls the Manage REST API. It is safe for concurrent use.
+type Client struct {
+	api         *httpapi.Client
+	maxRetries  int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	breaker     *breaker
+}
+
+// NewClient returns a Client for the site described by c.
+func NewClient(c Config) *Client {
+	site := strings.TrimSuffix(c.Site, "/")
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Company+"+"+c.PublicKey+":"+c.PrivateKey))
+	cl := &Client{
+		api: httpapi.New("manage", site+"/"+c.Release+"/apis/"+cmp.Or(c.APIVersion, DefaultAPIVersion), c.Timeout, func(r *http.Request) {
+			r.Header.Set("clientId", c.ClientID)
+			r.Header.Set("Authorization", auth)
+		}),
+		maxRetries:  cmp.Or(c.MaxRetries, 3),
+		baseBackoff: cmp.Or(c.BaseBackoff, 500*time.Millisecond),
+		maxBackoff:  cmp.Or(c.MaxBackoff, 30*time.Second),
+		breaker: &breaker{
+			threshold: cmp.Or(c.BreakerThreshold, 5),
+			cooldown:  cmp.Or(c.BreakerCooldown, time.Minute),
+		},
+	}
+	cl.maxRetries = max(cl.maxRetries, 0)
+	return cl
+}
+
+// GetTicket fetches a service ticket by ID.
+func (c *Client) GetTicket(ctx context.Context, id int) (*Ticket, error) {
+	var t Ticket
+	if err := c.do(ctx, http.MethodGet, "/service/tickets/"+strconv.Itoa(id), nil, nil, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateTicket opens a new service ticket and returns it as Manage saved it.
+func (c *Client) CreateTicket(ctx context.Context, t PostTicket) (*Ticket, error) {
+	var out Ticket
+	if err := c.do(ctx, http.MethodPost, "/service/tickets", nil, t, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AddNote adds a note to a service ticket.
+func (c *Client) AddNote(ctx context.Context, ticketID int, n TicketNote) error {
+	return c.do(ctx, http.MethodPost, "/service/tickets/"+strconv.Itoa(ticketID)+"/notes", nil, n, nil)
+}
+
+// UpdateTicket applies JSON Patch operations to a service ticket and returns the updated ticket.
+func (c *Client) UpdateTicket(ctx context.Context, id int, ops []PatchOp) (*Ticket, error) {
+	var t Ticket
+	if err := c.do(ctx, http.MethodPatch, "/service/tickets/"+strconv.Itoa(id), nil, ops, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// TicketQuery filters SearchTickets. Conditions and CustomFieldConditions use the Manage query syntax,
+// e.g. `company/id=19786 and closedFlag=false` and `caption="Tunnel" and value="hq-dc1"`.
+type TicketQuery struct {
+	Conditions            string
+	CustomFieldConditions string
+	OrderBy               string // e.g. `dateEntered desc`
+	PageSize              int    // 0 uses the Manage default
+}
+
+// SearchTickets returns the service tickets matching q.
+func (c *Client) SearchTickets(ctx context.Context, q TicketQuery) ([]Ticket, error) {
+	v := url.Values{}
+	if q.Conditions != "" {
+		v.Set("conditions", q.Conditions)
+	}
+	if q.CustomFieldConditions != "" {
+		v.Set("customFieldConditions", q.CustomFieldConditions)
+	}
+	if q.OrderBy != "" {
+		v.Set("orderBy", q.OrderBy)
+	}
+	if q.PageSize > 0 {
+		v.Set("pageSize", strconv.Itoa(q.PageSize))
+	}
+	var ts []Ticket
+	if err := c.do(ctx, http.MethodGet, "/service/tickets", v, nil, &ts); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Quote returns s as a double-quoted string for use in a conditions query.
+func Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// BoardStatuses returns every status defined on a service board.
+func (c *Client) BoardStatuses(ctx context.Context, boardID int) ([]BoardStatus, error) {
+	q := url.Values{}
+	q.Set("pageSize", "1000")
+	var ss []BoardStatus
+	if err := c.do(ctx, http.MethodGet, "/service/boards/"+strconv.Itoa(boardID)+"/statuses", q, nil, &ss); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// do sends a request to path and decodes a 2xx JSON response into out, if out is not nil.
+// Temporary failures are retried with backoff, and the call fails fast with ErrCircuitOpen while
+// Manage is known to be down. Non-2xx responses are returned as *APIError.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	if !c.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = newAPIError(c.api.Do(ctx, method, path, query, in, out))
+		if err == nil || attempt >= c.maxRetries || !retryable(method, err) {
+			break
+		}
+		if sleep(ctx, backoff(attempt+1, c.baseBackoff, c.maxBackoff, err)) != nil {
+			break
+		}
+	}
+	c.breaker.record(err)
+	return err
+}
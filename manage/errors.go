@@ -0,0 +1,84 @@
+package manage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"pingo/internal/httpapi"
+)
+
+// Errors matched by errors.Is against anything the client returns.
+var (
+	ErrNotFound     = httpapi.ErrNotFound
+	ErrUnauthorized = httpapi.ErrUnauthorized
+)
+
+// RequestError is returned when a request never got a response, e.g. a DNS failure, refused connection or timeout.
+type RequestError = httpapi.RequestError
+
+// FieldError is one entry of the errors list in a Manage error body.
+type FieldError struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+}
+
+// APIError is returned when Manage answers with a non-2xx status. Code, Message and Errors are
+// decoded from the Manage error body when there is one.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Header     http.Header
+	Code       string       `json:"code"`
+	Message    string       `json:"message"`
+	Errors     []FieldError `json:"errors"`
+	Body       string       // Raw body, kept when it is not a Manage error document
+}
+
+// newAPIError returns err as an *APIError if it is a non-2xx response, or unchanged otherwise.
+func newAPIError(err error) error {
+	var he *httpapi.Error
+	if !errors.As(err, &he) {
+		return err
+	}
+	e := &APIError{Method: he.Method, Path: he.Path, StatusCode: he.StatusCode, Header: he.Header}
+	if err := json.Unmarshal([]byte(he.Body), e); err != nil || (e.Code == "" && e.Message == "") {
+		e.Code, e.Message, e.Errors = "", "", nil
+		e.Body = he.Body
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "manage: %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		fmt.Fprintf(&b, ": %s", e.Code)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	for _, fe := range e.Errors {
+		fmt.Fprintf(&b, " [%s %s: %s]", fe.Field, fe.Code, fe.Message)
+	}
+	if e.Body != "" {
+		fmt.Fprintf(&b, ": %s", e.Body)
+	}
+	return b.String()
+}
+
+// Is lets errors.Is match ErrNotFound and ErrUnauthorized by status code.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
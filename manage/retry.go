@@ -0,0 +1,143 @@
+package manage
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting Manage while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("manage: circuit breaker open, Manage is unavailable")
+
+// maxRetryAfter caps how long a Retry-After header can make a single call wait.
+const maxRetryAfter = 2 * time.Minute
+
+// IsTemporary reports whether err is worth retrying later: the request never got an answer,
+// Manage is rate limiting or failing, or the circuit breaker is open.
+func IsTemporary(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	return false
+}
+
+// retryable reports whether a failed attempt of method may be sent again. Requests that are not
+// idempotent are only retried when Manage certainly did not act on them, so a timed out POST
+// never opens a second ticket.
+func retryable(method string, err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return method != http.MethodPost
+		}
+		return false
+	}
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+	if method != http.MethodPost {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// backoff returns how long to wait before retry number attempt (starting at 1): exponential from
+// base, capped at max, with full jitter. A Retry-After header on err takes precedence.
+func backoff(attempt int, base, max time.Duration, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if d, ok := parseRetryAfter(apiErr.Header.Get("Retry-After")); ok {
+			return min(d, maxRetryAfter)
+		}
+	}
+	d := base << (attempt - 1)
+	if d <= 0 || d > max {
+		d = max
+	}
+	return rand.N(d) + 1
+}
+
+// parseRetryAfter understands both forms of Retry-After: delay seconds and an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// breaker is a consecutive-failure circuit breaker. After threshold temporary failures in a row it
+// opens for cooldown, then lets a single trial request through; success closes it again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // A half-open trial request is in flight
+}
+
+// allow reports whether a request may be sent now.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record updates the breaker with the outcome of a request that allow let through.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil || !IsTemporary(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
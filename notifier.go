@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"pingo/notify"
+)
+
+// Notification events. Every event except recovered is sent once per incident, and a host key mismatch once per key.
+const (
+	EventDown              = "down"               // The tunnel went Down
+	EventDegraded          = "degraded"           // The tunnel is over its loss, latency or jitter thresholds
+	EventFlapping          = "flapping"           // The tunnel keeps going down and coming back
+	EventRemediationFailed = "remediation-failed" // A restart failed, or the restart limit was reached
+	EventHostKeyMismatch   = "host-key-mismatch"  // The device offered an unexpected SSH host key, so pingo did not log in
+	EventRecovered         = "recovered"          // The tunnel is Up again after any of the above
+)
+
+// notifyEvents lists every event.
+var notifyEvents = []string{EventDown, EventDegraded, EventFlapping, EventRemediationFailed, EventHostKeyMismatch, EventRecovered}
+
+// eventSeverity is the severity of each event. A recovery takes the highest severity sent during the outage,
+// so a channel that only gets critical alerts also hears when they are over.
+var eventSeverity = map[string]string{
+	EventDown:              notify.SeverityCritical,
+	EventDegraded:          notify.SeverityWarning,
+	EventFlapping:          notify.SeverityWarning,
+	EventRemediationFailed: notify.SeverityCritical,
+	EventHostKeyMismatch:   notify.SeverityCritical,
+}
+
+// severities lists every severity, lowest first.
+var severities = []string{notify.SeverityInfo, notify.SeverityWarning, notify.SeverityCritical}
+
+// notifyTimeout bounds a single notification, so a slow channel can't hold up shutdown or `pingo check`.
+const notifyTimeout = 30 * time.Second
+
+// NotifierConfig is a chat channel or webhook that gets a message when a tunnel changes state.
+// Notifications are sent alongside tickets, not instead of them.
+type NotifierConfig struct {
+	Type        string            `yaml:"type"`        // slack, teams or webhook
+	URL         string            `yaml:"url"`         // Incoming webhook URL
+	Token       string            `yaml:"token"`       // webhook only: sent as a bearer token
+	Headers     map[string]string `yaml:"headers"`     // webhook only: extra headers
+	Timeout     time.Duration     `yaml:"timeout"`     // Per-request timeout, defaults to 30s
+	Sites       []string          `yaml:"sites"`       // Tunnel names or shell patterns such as acme-*; empty means every tunnel
+	Events      []string          `yaml:"events"`      // Empty means every event
+	MinSeverity string            `yaml:"minSeverity"` // info (default), warning or critical
+}
+
+func (n NotifierConfig) validate(prefix string) error {
+	var errs []error
+	if !slices.Contains([]string{"slack", "teams", "webhook"}, n.Type) {
+		errs = append(errs, fmt.Errorf("%s.type must be slack, teams or webhook, got %q", prefix, n.Type))
+	}
+	if n.URL == "" {
+		errs = append(errs, fmt.Errorf("%s.url is required", prefix))
+	}
+	for _, p := range n.Sites {
+		if _, err := path.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s.sites: bad pattern %q: %w", prefix, p, err))
+		}
+	}
+	for _, e := range n.Events {
+		if !slices.Contains(notifyEvents, e) {
+			errs = append(errs, fmt.Errorf("%s.events: unknown event %q, expected one of %s", prefix, e, strings.Join(notifyEvents, ", ")))
+		}
+	}
+	if n.MinSeverity != "" && !slices.Contains(severities, n.MinSeverity) {
+		errs = append(errs, fmt.Errorf("%s.minSeverity must be info, warning or critical, got %q", prefix, n.MinSeverity))
+	}
+	return errors.Join(errs...)
+}
+
+// wants reports whether the channel wants m.
+func (n NotifierConfig) wants(m notify.Message) bool {
+	if len(n.Events) > 0 && !slices.Contains(n.Events, m.Event) {
+		return false
+	}
+	if slices.Index(severities, m.Severity) < slices.Index(severities, n.MinSeverity) {
+		return false
+	}
+	if len(n.Sites) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(n.Sites, func(p string) bool {
+		ok, _ := path.Match(p, m.Site)
+		return ok
+	})
+}
+
+// notifier is a configured channel.
+type notifier struct {
+	name string
+	cfg  NotifierConfig
+	notify.Sender
+}
+
+// notifiers holds every configured channel, rebuilt from cfg at startup and on reload.
+var notifiers []notifier
+
+// notifications tracks messages still being sent, so `pingo check` and shutdown can wait for them.
+var notifications sync.WaitGroup
+
+// newNotifiers builds every channel in c.
+func newNotifiers(c *Config) []notifier {
+	var ns []notifier
+	for _, name := range slices.Sorted(maps.Keys(c.Notifiers)) {
+		n := c.Notifiers[name]
+		nc := notify.Config{URL: n.URL, Token: n.Token, Headers: n.Headers, Timeout: n.Timeout}
+		var s notify.Sender
+		switch n.Type {
+		case "slack":
+			s = notify.NewSlack(nc)
+		case "teams":
+			s = notify.NewTeams(nc)
+		default:
+			s = notify.NewWebhook(nc)
+		}
+		ns = append(ns, notifier{name: name, cfg: n, Sender: s})
+	}
+	return ns
+}
+
+// sendNotification sends m to every channel that wants it in the background. Failures are logged and not retried,
+// since a late alert is worth little and the ticket is still there.
+func sendNotification(m notify.Message) {
+	for _, n := range notifiers {
+		if !n.cfg.wants(m) {
+			continue
+		}
+		notifications.Add(1)
+		go func() {
+			defer notifications.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+			if err := n.Send(ctx, m); err != nil {
+				siteLog(m.Site, fmt.Sprintf("Failed to send the %s notification to %s: %v", m.Event, n.name, err))
+			}
+		}()
+	}
+}
+
+// alert notifies the site's channels of event and remembers its severity for the recovery message.
+// An event already sent since the tunnel was last Up is skipped, except host key mismatches, which
+// hostKeyMismatch already limits to one per key. The tunnel state rather than the incident keeps track,
+// since the first down alert goes out before escalate opens the incident.
+func (s *Site) alert(event, text string) {
+	sev := eventSeverity[event]
+	var repeat bool
+	err := state.Update(s.Name, func(ts *TunnelState) {
+		if event != EventHostKeyMismatch {
+			if repeat = slices.Contains(ts.Alerts, event); repeat {
+				return
+			}
+			ts.Alerts = append(ts.Alerts, event)
+		}
+		if slices.Index(severities, sev) > slices.Index(severities, ts.Alerted) {
+			ts.Alerted = sev
+		}
+	})
+	if err != nil {
+		s.Log(fmt.Sprintf("Failed to save state: %v", err))
+	}
+	if repeat {
+		s.Log(fmt.Sprintf("Already sent a %s notification for this incident", event))
+		return
+	}
+	s.send(event, sev, text)
+}
+
+// alertRecovered sends a recovered notification if anything was sent during the outage that just ended,
+// and forgets the events sent so the next outage alerts afresh.
+func (s *Site) alertRecovered(text string) {
+	var sev string
+	err := state.Update(s.Name, func(ts *TunnelState) { sev, ts.Alerted, ts.Alerts = ts.Alerted, "", nil })
+	if err != nil {
+		s.Log(fmt.Sprintf("Failed to save state: %v", err))
+	}
+	if sev != "" {
+		s.send(EventRecovered, sev, text)
+	}
+}
+
+// send notifies the site's channels of event without recording it.
+func (s *Site) send(event, severity, text string) {
+	sendNotification(notify.Message{
+		Event:    event,
+		Site:     s.Name,
+		Severity: severity,
+		Title:    fmt.Sprintf("%s: tunnel %s", s.Name, strings.ReplaceAll(event, "-", " ")),
+		Text:     text,
+		Resolved: event == EventRecovered,
+		Time:     time.Now(),
+	})
+}
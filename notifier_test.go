@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"pingo/notify"
+)
+
+// fakeProber reports the target up or down as told, without touching the network.
+type fakeProber struct {
+	name string
+	up   bool
+}
+
+func (p *fakeProber) Probe(ctx context.Context) (ProbeStats, error) {
+	if !p.up {
+		return ProbeStats{Sent: 3, Loss: 100}, nil
+	}
+	return ProbeStats{Sent: 3, Recv: 3, MinRtt: time.Millisecond, AvgRtt: time.Millisecond, MaxRtt: time.Millisecond}, nil
+}
+
+func (p *fakeProber) String() string { return p.name }
+
+// recordingSender keeps every message it is asked to send.
+type recordingSender struct {
+	mu   sync.Mutex
+	sent []notify.Message
+}
+
+func (r *recordingSender) Send(ctx context.Context, m notify.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+// count returns how many event messages were sent, once every pending send is done.
+func (r *recordingSender) count(event string) int {
+	notifications.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, m := range r.sent {
+		if m.Event == event {
+			n++
+		}
+	}
+	return n
+}
+
+// useTempStores gives the test an empty state store and outbox in a temporary directory, and a single
+// notification channel that records what it is sent.
+func useTempStores(t *testing.T) *recordingSender {
+	dir := t.TempDir()
+	prevState, prevOutbox, prevNotifiers := state, outbox, notifiers
+	t.Cleanup(func() { state, outbox, notifiers = prevState, prevOutbox, prevNotifiers })
+	var err error
+	if state, err = OpenStateStore(filepath.Join(dir, "state.json")); err != nil {
+		t.Fatal(err)
+	}
+	if outbox, err = OpenOutbox(filepath.Join(dir, "outbox.json")); err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordingSender{}
+	notifiers = []notifier{{name: "test", Sender: rec}}
+	return rec
+}
+
+func TestAlertOncePerOutage(t *testing.T) {
+	rec := useTempStores(t)
+
+	tun, wan, dev := &fakeProber{name: "tun", up: true}, &fakeProber{name: "wan"}, &fakeProber{name: "dev"}
+	s := &Site{
+		TunnelConfig: TunnelConfig{Name: "hq"},
+		health:       NewHealth(HealthConfig{FailuresToDown: 1, SuccessesToUp: 2, FlapCount: 5, FlapWindow: time.Hour}, time.Now()),
+		tun:          tun, wan: wan, dev: dev,
+	}
+	check := func(up bool, want HealthState) {
+		t.Helper()
+		tun.up = up
+		s.Check(context.Background())
+		if s.health.State != want {
+			t.Fatalf("tunnel is %s, want %s", s.health.State, want)
+		}
+	}
+
+	check(false, StateDown)
+	check(true, StateRecovering)
+	check(false, StateDown)
+	if n := rec.count(EventDown); n != 1 {
+		t.Errorf("Down -> Recovering -> Down sent %d down notifications, want 1", n)
+	}
+
+	check(true, StateRecovering)
+	check(true, StateUp)
+	check(false, StateDown)
+	if n, r := rec.count(EventDown), rec.count(EventRecovered); n != 2 || r != 1 {
+		t.Errorf("a second outage left %d down and %d recovered notifications, want 2 and 1", n, r)
+	}
+}
@@ -0,0 +1,126 @@
+// Package notify sends alert messages to Slack incoming webhooks, Microsoft Teams workflows and connectors,
+// and generic HTTP webhooks.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"pingo/internal/httpapi"
+)
+
+// Severities, lowest first.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Message is one alert. Generic webhooks receive it as JSON as is.
+type Message struct {
+	Event    string    `json:"event"`
+	Site     string    `json:"site"`
+	Severity string    `json:"severity"`
+	Title    string    `json:"title"`
+	Text     string    `json:"text"`
+	Resolved bool      `json:"resolved"` // The message says an earlier alert is over; chat channels show it in green
+	Time     time.Time `json:"time"`
+}
+
+// Sender delivers messages to one channel.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Config holds the URL a channel's messages are posted to.
+type Config struct {
+	URL     string
+	Token   string            // Webhook only: sent as a bearer token if set
+	Headers map[string]string // Webhook only: extra headers sent with every message
+	Timeout time.Duration     // Per-request timeout, defaults to 30s
+}
+
+// NewSlack returns a Sender that posts to a Slack incoming webhook.
+func NewSlack(c Config) Sender {
+	return &slack{api: httpapi.New("slack", c.URL, c.Timeout, nil)}
+}
+
+// NewTeams returns a Sender that posts an Adaptive Card to a Teams workflow or incoming webhook connector.
+func NewTeams(c Config) Sender {
+	return &teams{api: httpapi.New("teams", c.URL, c.Timeout, nil)}
+}
+
+// NewWebhook returns a Sender that posts the Message itself as JSON.
+func NewWebhook(c Config) Sender {
+	return &webhook{api: httpapi.New("webhook", c.URL, c.Timeout, func(r *http.Request) {
+		for k, v := range c.Headers {
+			r.Header.Set(k, v)
+		}
+		if c.Token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+	})}
+}
+
+type slack struct{ api *httpapi.Client }
+
+// slackColors are the attachment bar colors per severity.
+var slackColors = map[string]string{SeverityInfo: "good", SeverityWarning: "warning", SeverityCritical: "danger"}
+
+func (s *slack) Send(ctx context.Context, m Message) error {
+	var raw []byte // Slack answers "ok" as plain text
+	return s.api.Do(ctx, http.MethodPost, "", nil, map[string]any{
+		"text": fmt.Sprintf("%s: %s", m.Title, m.Text), // Shown in notifications
+		"attachments": []map[string]any{{
+			"color":  color(slackColors, m),
+			"title":  m.Title,
+			"text":   m.Text,
+			"footer": fmt.Sprintf("pingo | %s | %s", m.Site, m.Severity),
+			"ts":     m.Time.Unix(),
+		}},
+	}, &raw)
+}
+
+type teams struct{ api *httpapi.Client }
+
+// teamsColors are the Adaptive Card title colors per severity.
+var teamsColors = map[string]string{SeverityInfo: "Good", SeverityWarning: "Warning", SeverityCritical: "Attention"}
+
+func (t *teams) Send(ctx context.Context, m Message) error {
+	card := map[string]any{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []map[string]any{
+			{"type": "TextBlock", "text": m.Title, "weight": "Bolder", "size": "Medium", "color": color(teamsColors, m), "wrap": true},
+			{"type": "TextBlock", "text": m.Text, "wrap": true},
+			{"type": "FactSet", "facts": []map[string]string{
+				{"title": "Site", "value": m.Site},
+				{"title": "Severity", "value": m.Severity},
+				{"title": "Time", "value": m.Time.Format(time.DateTime)},
+			}},
+		},
+	}
+	var raw []byte
+	return t.api.Do(ctx, http.MethodPost, "", nil, map[string]any{
+		"type":        "message",
+		"attachments": []map[string]any{{"contentType": "application/vnd.microsoft.card.adaptive", "content": card}},
+	}, &raw)
+}
+
+// color picks the color for m from colors, using the info color for resolved messages.
+func color(colors map[string]string, m Message) string {
+	if m.Resolved {
+		return colors[SeverityInfo]
+	}
+	return colors[m.Severity]
+}
+
+type webhook struct{ api *httpapi.Client }
+
+func (w *webhook) Send(ctx context.Context, m Message) error {
+	var raw []byte
+	return w.api.Do(ctx, http.MethodPost, "", nil, m, &raw)
+}
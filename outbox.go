@@ -0,0 +1,353 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outbox operation kinds.
+const (
+	OpCreate  = "create"  // Open a ticket for the incident, or adopt the one it already has
+	OpNote    = "note"    // Add a note to the incident's ticket
+	OpUpdate  = "update"  // Patch the incident's ticket, e.g. when the failure moves to another stage
+	OpResolve = "resolve" // Move the incident's ticket to a resolved status
+)
+
+// OutboxOp is a ticket operation waiting to be delivered to the site's ticketer.
+type OutboxOp struct {
+	ID         string         `json:"id"` // Dedupe key, an identical operation already waiting is not queued twice
+	Site       string         `json:"site"`
+	Ticketer   string         `json:"ticketer"` // Name of the backend the incident's ticket lives in
+	Kind       string         `json:"kind"`
+	IncidentID string         `json:"incidentId"`
+	Stage      string         `json:"stage,omitempty"`      // create, update
+	TicketID   TicketID       `json:"ticketId,omitempty"`   // Filled in once the incident's create is delivered
+	Request    *TicketRequest `json:"request,omitempty"`    // create, update
+	Text       string         `json:"text,omitempty"`       // note
+	Resolution bool           `json:"resolution,omitempty"` // note, sets the resolution flag
+	StatusID   int            `json:"statusId,omitempty"`   // resolve
+	Queued     time.Time      `json:"queued"`
+	Attempts   int            `json:"attempts"`
+	NextTry    time.Time      `json:"nextTry"`
+	LastError  string         `json:"lastError,omitempty"`
+}
+
+// Outbox is a persistent queue of ticket operations. Sites write every create, note, update and resolve here
+// first, and a background worker delivers them to the ticketer with retries, so nothing is lost while
+// it is unreachable or pingo restarts.
+type Outbox struct {
+	path string
+	mu   sync.Mutex
+	ops  []*OutboxOp
+	wake chan struct{}
+}
+
+// outbox is opened at startup from cfg.OutboxFile.
+var outbox *Outbox
+
+// outboxMaxBackoff caps the wait between delivery attempts of a single operation.
+const outboxMaxBackoff = 10 * time.Minute
+
+// OpenOutbox loads the outbox file at path. A missing file starts an empty outbox.
+func OpenOutbox(path string) (*Outbox, error) {
+	o := &Outbox{path: path, wake: make(chan struct{}, 1)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading outbox %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &o.ops); err != nil {
+		return nil, fmt.Errorf("parsing outbox %s: %w", path, err)
+	}
+	return o, nil
+}
+
+// Enqueue saves op to the outbox and wakes the worker. An operation with the same ID that is
+// still waiting is kept instead, so repeated checks of the same outage don't pile up duplicates.
+func (o *Outbox) Enqueue(op OutboxOp) error {
+	op.ID = op.dedupeKey()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, q := range o.ops {
+		if q.ID == op.ID {
+			return nil
+		}
+	}
+	op.Queued = time.Now()
+	o.ops = append(o.ops, &op)
+	if err := o.save(); err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dedupeKey identifies an operation by what it does to which incident.
+func (op OutboxOp) dedupeKey() string {
+	h := sha256.Sum256([]byte(op.Text))
+	return fmt.Sprintf("%s/%s/%s/%s/%d/%s", op.Site, op.IncidentID, op.Kind, op.Stage, op.StatusID, hex.EncodeToString(h[:8]))
+}
+
+// Run delivers queued operations until ctx is cancelled, waking when something is queued and
+// at least every poll to retry operations whose backoff has expired.
+func (o *Outbox) Run(ctx context.Context, poll time.Duration) {
+	for {
+		o.Flush(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Flush makes one delivery attempt for every operation that is due, oldest first.
+func (o *Outbox) Flush(ctx context.Context) {
+	now := time.Now()
+	o.mu.Lock()
+	var due []string
+	for _, op := range o.ops {
+		if !op.NextTry.After(now) {
+			due = append(due, op.ID)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, id := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		op, ok := o.get(id)
+		if !ok {
+			continue
+		}
+		if op.Kind != OpCreate && op.TicketID == "" {
+			if o.createPending(op.Site, op.IncidentID) {
+				continue // Wait for the ticket to exist
+			}
+			// The create may have been delivered between the site queueing op and the outbox saving it
+			if op.TicketID = incidentTicket(op.Site, op.IncidentID); op.TicketID == "" {
+				o.finish(op, "", fmt.Errorf("no ticket was created for incident %s", op.IncidentID))
+				continue
+			}
+		}
+		ticketID, err := o.deliver(ctx, op)
+		o.finish(op, ticketID, err)
+	}
+}
+
+// deliver sends a single operation to the op's ticketer. For creates it returns the ticket ID that now belongs to the incident.
+func (o *Outbox) deliver(ctx context.Context, op OutboxOp) (TicketID, error) {
+	name := cmp.Or(op.Ticketer, TicketerConnectWise) // Operations queued by older versions went to Manage
+	t, ok := ticketers[name]
+	if !ok {
+		return "", fmt.Errorf("no ticketer named %q", name)
+	}
+	if (op.Kind == OpCreate || op.Kind == OpUpdate) && op.Request == nil {
+		return "", fmt.Errorf("ticket %s has no ticket fields", op.Kind)
+	}
+	switch op.Kind {
+	case OpCreate:
+		return o.deliverCreate(ctx, t, op)
+	case OpNote:
+		return op.TicketID, t.AddNote(ctx, op.TicketID, op.Text, op.Resolution)
+	case OpUpdate:
+		u, ok := t.(ticketUpdater)
+		if !ok {
+			return op.TicketID, nil // The note queued with the update still records the new stage
+		}
+		err := u.Update(ctx, op.TicketID, *op.Request)
+		if err == nil {
+			siteLog(op.Site, fmt.Sprintf("Ticket %s updated for the %s stage", op.TicketID, op.Stage))
+		}
+		return op.TicketID, err
+	case OpResolve:
+		err := t.Resolve(ctx, op.TicketID, op.StatusID)
+		if err == nil {
+			siteLog(op.Site, fmt.Sprintf("Ticket %s resolved", op.TicketID))
+		}
+		return op.TicketID, err
+	}
+	return "", fmt.Errorf("unknown outbox operation %q", op.Kind)
+}
+
+// deliverCreate adopts the ticket already recorded for the incident if it is still open, or else an open
+// ticket for the tunnel found by searching the ticketer, and only creates a new one if there is neither.
+func (o *Outbox) deliverCreate(ctx context.Context, t Ticketer, op OutboxOp) (TicketID, error) {
+	if inc := state.Get(op.Site).Incident; inc != nil && inc.ID == op.IncidentID && inc.TicketID != "" {
+		valid, status, err := t.IsOpen(ctx, inc.TicketID)
+		if status != "" {
+			siteLog(op.Site, fmt.Sprintf("Ticket %s status: %s", inc.TicketID, status))
+		}
+		switch {
+		case errors.Is(err, ErrTicketNotFound):
+			siteLog(op.Site, fmt.Sprintf("Ticket %s no longer exists. Creating a new ticket.", inc.TicketID))
+		case err != nil:
+			return "", err
+		case valid:
+			siteLog(op.Site, fmt.Sprintf("Ticket %s is valid ticket. Keeping it for this incident.", inc.TicketID))
+			return inc.TicketID, nil
+		default:
+			siteLog(op.Site, fmt.Sprintf("Ticket %s is not active. Creating a new ticket.", inc.TicketID))
+		}
+	}
+	if f, ok := t.(ticketFinder); ok {
+		found, summary, err := f.FindOpen(ctx, *op.Request)
+		switch {
+		case err != nil && isTemporary(err):
+			return "", err // Creating now could duplicate a ticket the search would have found
+		case err != nil:
+			siteLog(op.Site, fmt.Sprintf("Could not search for an open ticket, creating a new one: %v", err))
+		case found != "":
+			siteLog(op.Site, fmt.Sprintf("Found open ticket %s (%s) for this tunnel. Adopting it instead of creating a new one.", found, summary))
+			return found, nil
+		}
+	}
+	id, err := t.Create(ctx, *op.Request)
+	if err != nil {
+		return "", err
+	}
+	siteLog(op.Site, fmt.Sprintf("Ticket created with ID: %s", id))
+	return id, nil
+}
+
+// finish records the outcome of delivering op. Successful and permanently failed operations leave
+// the outbox; temporary failures are retried with exponential backoff. A delivered create hands its
+// ticket ID to the incident's waiting operations and to the state store.
+func (o *Outbox) finish(op OutboxOp, ticketID TicketID, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	i := o.index(op.ID)
+	if i < 0 {
+		return
+	}
+	q := o.ops[i]
+	switch {
+	case err == nil:
+		o.ops = append(o.ops[:i], o.ops[i+1:]...)
+		if op.Kind == OpCreate {
+			for _, w := range o.ops {
+				if w.Site == op.Site && w.IncidentID == op.IncidentID {
+					w.TicketID = ticketID
+				}
+			}
+			o.recordTicket(op, ticketID)
+		}
+	case isTemporary(err):
+		q.Attempts++
+		q.LastError = err.Error()
+		q.NextTry = time.Now().Add(min(time.Duration(1<<min(q.Attempts, 20))*time.Second, outboxMaxBackoff))
+		if q.Attempts == 1 {
+			siteLog(op.Site, fmt.Sprintf("The ticketer is unavailable. Ticket %s is queued and will be retried: %v", op.Kind, err))
+		}
+	default:
+		o.ops = append(o.ops[:i], o.ops[i+1:]...)
+		siteLog(op.Site, fmt.Sprintf("Dropping ticket %s after a permanent failure: %v", op.Kind, err))
+		if op.Kind != OpCreate {
+			break
+		}
+		// A create that was re-validating the incident's ticket leaves its waiting operations on that ticket
+		if id := incidentTicket(op.Site, op.IncidentID); id != "" {
+			siteLog(op.Site, fmt.Sprintf("Keeping ticket %s for this incident.", id))
+			for _, w := range o.ops {
+				if w.Site == op.Site && w.IncidentID == op.IncidentID {
+					w.TicketID = id
+				}
+			}
+		}
+	}
+	if err := o.save(); err != nil {
+		siteLog(op.Site, fmt.Sprintf("Failed to save outbox: %v", err))
+	}
+}
+
+// recordTicket stores a delivered create's ticket ID on its incident, whether it is still open or has just ended.
+func (o *Outbox) recordTicket(op OutboxOp, ticketID TicketID) {
+	err := state.Update(op.Site, func(ts *TunnelState) {
+		for _, inc := range []*Incident{ts.Incident, ts.LastIncident} {
+			if inc != nil && inc.ID == op.IncidentID {
+				inc.TicketID = ticketID
+			}
+		}
+	})
+	if err != nil {
+		siteLog(op.Site, fmt.Sprintf("Failed to save state: %v", err))
+	}
+}
+
+// incidentTicket returns the ticket ID stored for the incident, whether it is still open or has just ended.
+func incidentTicket(site, incidentID string) TicketID {
+	ts := state.Get(site)
+	for _, inc := range []*Incident{ts.Incident, ts.LastIncident} {
+		if inc != nil && inc.ID == incidentID {
+			return inc.TicketID
+		}
+	}
+	return ""
+}
+
+// createPending reports whether a create for the incident is still waiting.
+func (o *Outbox) createPending(site, incidentID string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, op := range o.ops {
+		if op.Kind == OpCreate && op.Site == site && op.IncidentID == incidentID {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns a copy of the waiting operation with the given ID.
+func (o *Outbox) get(id string) (OutboxOp, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if i := o.index(id); i >= 0 {
+		return *o.ops[i], true
+	}
+	return OutboxOp{}, false
+}
+
+// index returns the position of the operation with the given ID, or -1. The caller must hold o.mu.
+func (o *Outbox) index(id string) int {
+	for i, op := range o.ops {
+		if op.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Len returns the number of operations still waiting.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.ops)
+}
+
+// save writes the outbox to disk. The caller must hold o.mu.
+func (o *Outbox) save() error {
+	data, err := json.MarshalIndent(o.ops, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling outbox: %w", err)
+	}
+	if err := writeFileAtomic(o.path, data); err != nil {
+		return fmt.Errorf("writing outbox: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeTicketer is an in-memory Ticketer that can search for open tickets.
+type fakeTicketer struct {
+	mu        sync.Mutex
+	next      int
+	open      map[TicketID]bool
+	notes     map[TicketID][]string
+	found     TicketID // Returned by FindOpen
+	createErr error    // Returned by Create instead of a ticket
+	creates   int
+}
+
+func (f *fakeTicketer) Create(ctx context.Context, r TicketRequest) (TicketID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.createErr != nil {
+		return "", f.createErr
+	}
+	f.creates++
+	f.next++
+	id := TicketID(fmt.Sprint(f.next))
+	f.open[id] = true
+	return id, nil
+}
+
+func (f *fakeTicketer) IsOpen(ctx context.Context, id TicketID) (bool, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	open, ok := f.open[id]
+	if !ok {
+		return false, "", ErrTicketNotFound
+	}
+	return open, "", nil
+}
+
+func (f *fakeTicketer) AddNote(ctx context.Context, id TicketID, text string, resolution bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.notes[id] = append(f.notes[id], text)
+	return nil
+}
+
+func (f *fakeTicketer) Resolve(ctx context.Context, id TicketID, status int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.open[id] = false
+	return nil
+}
+
+func (f *fakeTicketer) FindOpen(ctx context.Context, r TicketRequest) (TicketID, string, error) {
+	return f.found, "", nil
+}
+
+// temporaryError is a failure the outbox retries.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "unavailable" }
+func (temporaryError) Temporary() bool { return true }
+
+// newTestOutbox opens an empty outbox and state store in a temporary directory, with ft as the only ticketer,
+// and an open incident on site hq.
+func newTestOutbox(t *testing.T, ft *fakeTicketer, inc Incident) *Outbox {
+	dir := t.TempDir()
+	prevState, prevTicketers := state, ticketers
+	t.Cleanup(func() { state, ticketers = prevState, prevTicketers })
+	var err error
+	if state, err = OpenStateStore(filepath.Join(dir, "state.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Update("hq", func(ts *TunnelState) { ts.Incident = &inc }); err != nil {
+		t.Fatal(err)
+	}
+	ft.open, ft.notes = map[TicketID]bool{}, map[TicketID][]string{}
+	ticketers = map[string]Ticketer{"fake": ft}
+	o, err := OpenOutbox(filepath.Join(dir, "outbox.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func enqueue(t *testing.T, o *Outbox, op OutboxOp) {
+	t.Helper()
+	op.Site, op.Ticketer, op.IncidentID = "hq", "fake", "inc1"
+	if op.Kind == OpCreate {
+		op.Request = &TicketRequest{Site: "hq", Summary: "Tunnel down - hq"}
+	}
+	if err := o.Enqueue(op); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutboxCreateThenNote(t *testing.T) {
+	ft := &fakeTicketer{}
+	o := newTestOutbox(t, ft, Incident{ID: "inc1"})
+	enqueue(t, o, OutboxOp{Kind: OpCreate})
+	enqueue(t, o, OutboxOp{Kind: OpNote, Text: "restarting"})
+	enqueue(t, o, OutboxOp{Kind: OpNote, Text: "restarting"})
+	o.Flush(context.Background())
+
+	if o.Len() != 0 || ft.creates != 1 {
+		t.Fatalf("after a flush %d operations wait and %d tickets were created, want 0 and 1", o.Len(), ft.creates)
+	}
+	if got := state.Get("hq").Incident.TicketID; got != "1" {
+		t.Errorf("incident ticket = %q, want 1", got)
+	}
+	if notes := ft.notes["1"]; len(notes) != 1 || notes[0] != "restarting" {
+		t.Errorf("notes on ticket 1 = %q, want the duplicate note queued once", notes)
+	}
+}
+
+func TestOutboxCreateAdoptsTicket(t *testing.T) {
+	ft := &fakeTicketer{}
+	o := newTestOutbox(t, ft, Incident{ID: "inc1", TicketID: "40"})
+	ft.open["40"] = true
+	enqueue(t, o, OutboxOp{Kind: OpCreate})
+	o.Flush(context.Background())
+	if ft.creates != 0 || state.Get("hq").Incident.TicketID != "40" {
+		t.Errorf("recorded open ticket: %d creates, incident ticket %q, want it kept", ft.creates, state.Get("hq").Incident.TicketID)
+	}
+
+	ft.open["40"] = false
+	ft.found = "41"
+	enqueue(t, o, OutboxOp{Kind: OpCreate, Stage: StageWAN})
+	o.Flush(context.Background())
+	if ft.creates != 0 || state.Get("hq").Incident.TicketID != "41" {
+		t.Errorf("closed ticket with an open one found: %d creates, incident ticket %q, want 41 adopted", ft.creates, state.Get("hq").Incident.TicketID)
+	}
+}
+
+func TestOutboxRetriesTemporaryFailures(t *testing.T) {
+	ft := &fakeTicketer{createErr: temporaryError{}}
+	o := newTestOutbox(t, ft, Incident{ID: "inc1"})
+	enqueue(t, o, OutboxOp{Kind: OpCreate})
+	enqueue(t, o, OutboxOp{Kind: OpNote, Text: "restarting"})
+	o.Flush(context.Background())
+
+	if o.Len() != 2 {
+		t.Fatalf("%d operations wait after a temporary failure, want the create and its note", o.Len())
+	}
+	if op := o.ops[0]; op.Attempts != 1 || op.NextTry.IsZero() || op.LastError == "" {
+		t.Errorf("failed create has attempts %d, next try %v, last error %q", op.Attempts, op.NextTry, op.LastError)
+	}
+
+	reopened, err := OpenOutbox(o.path)
+	if err != nil || reopened.Len() != 2 {
+		t.Errorf("reopened outbox has %d operations (%v), want both saved", reopened.Len(), err)
+	}
+}
+
+func TestOutboxPermanentCreateFailure(t *testing.T) {
+	ft := &fakeTicketer{createErr: errors.New("rejected")}
+	o := newTestOutbox(t, ft, Incident{ID: "inc1"})
+	enqueue(t, o, OutboxOp{Kind: OpCreate})
+	enqueue(t, o, OutboxOp{Kind: OpNote, Text: "restarting"})
+	o.Flush(context.Background())
+	if o.Len() != 0 || len(ft.notes) != 0 {
+		t.Errorf("without any ticket %d operations wait and notes went to %v, want everything dropped", o.Len(), ft.notes)
+	}
+
+	// A re-validation create that fails leaves its note on the ticket already recorded for the incident
+	o = newTestOutbox(t, ft, Incident{ID: "inc1", TicketID: "40"})
+	ft.open["40"] = false
+	enqueue(t, o, OutboxOp{Kind: OpCreate})
+	enqueue(t, o, OutboxOp{Kind: OpNote, Text: "restarting"})
+	o.Flush(context.Background())
+	if notes := ft.notes["40"]; len(notes) != 1 {
+		t.Errorf("notes on the recorded ticket = %q, want the waiting note", notes)
+	}
+}
+
+func TestOutboxNoteQueuedAfterCreate(t *testing.T) {
+	ft := &fakeTicketer{}
+	o := newTestOutbox(t, ft, Incident{ID: "inc1"})
+	enqueue(t, o, OutboxOp{Kind: OpCreate})
+	o.Flush(context.Background())
+
+	// Queued by a site that read the incident before the create was delivered
+	enqueue(t, o, OutboxOp{Kind: OpNote, Text: "restarting"})
+	o.Flush(context.Background())
+	if notes := ft.notes["1"]; len(notes) != 1 {
+		t.Errorf("notes on ticket 1 = %q, want the late note delivered", notes)
+	}
+}
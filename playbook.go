@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Playbook step actions.
+const (
+	ActionBounce  = "bounce"  // Tear down and re-initiate just this tunnel through the remediation driver (default)
+	ActionService = "service" // Restart the device's IPsec service, dropping every tunnel on it
+	ActionReboot  = "reboot"  // Reboot the device
+	ActionCommand = "command" // Run the step's own commands
+)
+
+// Playbook stop conditions. The playbook always stops once a step confirms the tunnel up.
+const (
+	StopOnUp     = "up"     // Otherwise go on to the next step (default)
+	StopOnFailed = "failed" // Also stop if the step's commands fail, rather than escalating
+)
+
+// PlaybookStep is one step of a tunnel's remediation playbook. After its action pingo waits, then probes
+// until the tunnel is confirmed up or the step times out. Only a probe that comes back up counts as success.
+type PlaybookStep struct {
+	Action   string        `yaml:"action"`   // bounce (default), service, reboot or command
+	Commands []string      `yaml:"commands"` // command: run in order on the device
+	Wait     time.Duration `yaml:"wait"`     // Pause after the action before probing, defaults to 10s, or 1m for reboot, but at most half the timeout
+	Timeout  time.Duration `yaml:"timeout"`  // Limit on the whole step, action included, defaults to 2m, or 10m for reboot
+	Verify   ProbeConfig   `yaml:"verify"`   // Probe that must come back up, defaults to the tunnel probe. Its address defaults to the tunnel's
+	StopOn   string        `yaml:"stopOn"`   // up (default) or failed
+}
+
+// defaultPlaybook is used when neither the tunnel nor the top-level playbook lists any steps:
+// bounce the tunnel and check that it came back.
+var defaultPlaybook = []PlaybookStep{{Action: ActionBounce}}
+
+// verifyRetry is the pause between verification probes that don't come back up.
+const verifyRetry = 5 * time.Second
+
+// errStillDown is returned by runStep when the step's commands ran but the tunnel wasn't confirmed up in time.
+var errStillDown = errors.New("tunnel is still down")
+
+// withDefaults fills in the settings left unset, verifying with tun unless the step has a probe of its own.
+func (p PlaybookStep) withDefaults(tun ProbeConfig, icmpMode string) PlaybookStep {
+	if p.Action == "" {
+		p.Action = ActionBounce
+	}
+	if p.Timeout == 0 {
+		p.Timeout = 2 * time.Minute
+		if p.Action == ActionReboot {
+			p.Timeout = 10 * time.Minute
+		}
+	}
+	if p.Wait == 0 {
+		p.Wait = 10 * time.Second
+		if p.Action == ActionReboot {
+			p.Wait = 1 * time.Minute
+		}
+		p.Wait = min(p.Wait, p.Timeout/2) // Leave time to probe in a short step
+	}
+	switch {
+	case p.Verify.Address == "" && p.Verify.Type == "":
+		p.Verify = tun
+	case p.Verify.Address == "":
+		p.Verify.Address = tun.Address
+		fallthrough
+	default:
+		p.Verify = p.Verify.withDefaults(icmpMode)
+	}
+	if p.StopOn == "" {
+		p.StopOn = StopOnUp
+	}
+	return p
+}
+
+// validate checks p, naming it prefix in errors. The tunnel's remediation driver r has to support the action.
+func (p PlaybookStep) validate(prefix string, r RemediationConfig) error {
+	var errs []error
+	d := r.RemediationDriver()
+	switch p.Action {
+	case ActionBounce:
+	case ActionService, ActionReboot:
+		if len(stepCommands(p, d)) == 0 {
+			errs = append(errs, fmt.Errorf("%s: the %s driver can't %s, use a command step instead", prefix, r.Driver, describeAction(p, d)))
+		}
+	case ActionCommand:
+		if len(p.Commands) == 0 {
+			errs = append(errs, fmt.Errorf("%s.commands is required for command steps", prefix))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.action must be bounce, service, reboot or command, got %q", prefix, p.Action))
+	}
+	if len(p.Commands) > 0 && p.Action != ActionCommand {
+		errs = append(errs, fmt.Errorf("%s.commands is only used by command steps", prefix))
+	}
+	if p.Wait < 0 || p.Timeout <= p.Wait {
+		errs = append(errs, fmt.Errorf("%s: timeout must be longer than wait", prefix))
+	}
+	if p.StopOn != StopOnUp && p.StopOn != StopOnFailed {
+		errs = append(errs, fmt.Errorf("%s.stopOn must be up or failed, got %q", prefix, p.StopOn))
+	}
+	errs = append(errs, p.Verify.validate(prefix+".verify"))
+	return errors.Join(errs...)
+}
+
+// stepCommands returns the commands p runs on the device.
+func stepCommands(p PlaybookStep, d RemediationDriver) []DeviceCommand {
+	switch p.Action {
+	case ActionService:
+		return d.RestartService()
+	case ActionReboot:
+		return d.Reboot()
+	case ActionCommand:
+		cmds := make([]DeviceCommand, len(p.Commands))
+		for i, c := range p.Commands {
+			cmds[i] = DeviceCommand{Cmd: c}
+		}
+		return cmds
+	default:
+		return d.Bounce()
+	}
+}
+
+// describeAction says what p does, e.g. "bounce swanctl child acme-hq-net".
+func describeAction(p PlaybookStep, d RemediationDriver) string {
+	switch p.Action {
+	case ActionService:
+		return "restart the IPsec service"
+	case ActionReboot:
+		return "reboot the device"
+	case ActionCommand:
+		return "run " + strings.Join(p.Commands, "; ")
+	default:
+		return fmt.Sprintf("bounce %s", d)
+	}
+}
+
+// runPlaybook works through the site's playbook until a step confirms the tunnel up or a step's stop condition
+// is met, noting each step's result on the incident's ticket. It returns 0 once the tunnel is confirmed up,
+// 4 if the last step's commands failed and 8 if they ran but the tunnel stayed down.
+func (s *Site) runPlaybook(ctx context.Context) int {
+	code, result := 0, ""
+	for i, step := range s.Playbook {
+		label := fmt.Sprintf("Playbook step %d/%d (%s)", i+1, len(s.Playbook), describeAction(step, s.driver))
+		s.Log(label + " starting")
+		start := time.Now()
+		stats, err := s.runStep(ctx, step)
+		took := time.Since(start).Round(time.Second)
+		if ctx.Err() != nil {
+			s.Log(fmt.Sprintf("%s interrupted by shutdown", label))
+			return 0
+		}
+		var mismatch *HostKeyMismatchError
+		switch {
+		case err == nil:
+			s.Log(fmt.Sprintf("%s: tunnel confirmed up after %s", label, took))
+			s.updateIncident(func(inc *Incident) { inc.RestartsSucceeded++ })
+			s.addNote(fmt.Sprintf("%s: tunnel confirmed up after %s. %s: %s.", label, took, step.Verify.Prober(), stats))
+			return 0
+		case errors.As(err, &mismatch):
+			s.Log(fmt.Sprintf("%s failed: %v", label, err))
+			s.addNote(fmt.Sprintf("%s failed: %v", label, err))
+			s.hostKeyMismatch(mismatch)
+			return 4
+		case errors.Is(err, errStillDown):
+			code, result = 8, fmt.Sprintf("%s: tunnel still down after %s. %s: %s.", label, took, step.Verify.Prober(), stats)
+		default:
+			code, result = 4, fmt.Sprintf("%s failed after %s: %v", label, took, err)
+		}
+		s.Log(result)
+		s.addNote(result)
+		if code == 4 && step.StopOn == StopOnFailed {
+			break
+		}
+	}
+	s.alert(EventRemediationFailed, fmt.Sprintf("Tunnel %s is still down after pingo's remediation playbook. %s", s.tun, result))
+	return code
+}
+
+// runStep runs step's action on the device, waits, then probes until the tunnel is up or the step times out.
+// It returns the last probe results, and errStillDown if the tunnel never came back up.
+func (s *Site) runStep(ctx context.Context, step PlaybookStep) (ProbeStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, step.Timeout)
+	defer cancel()
+	s.Log(fmt.Sprintf("Attempting to Tunnel into: %s to %s", s.SSH.route(), describeAction(step, s.driver)))
+	if err := sshIntoHost(ctx, s.Log, s.SSH, s.driver, stepCommands(step, s.driver)); err != nil {
+		s.Log(fmt.Sprintf("Failed to run command on device address %s: %v", s.SSH.Host, err))
+		return ProbeStats{}, err
+	}
+	s.Log(fmt.Sprintf("Command ran successfully on device address %s. Verifying with %s in %s", s.SSH.Host, step.Verify.Prober(), step.Wait))
+
+	var stats ProbeStats
+	verify := step.Verify.Prober()
+	for wait := step.Wait; ; wait = verifyRetry {
+		select {
+		case <-ctx.Done():
+			return stats, errStillDown
+		case <-time.After(wait):
+		}
+		var err error
+		if stats, err = s.test(ctx, verify); err == nil && stats.Up() {
+			return stats, nil
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyWith returns a quick tcp probe of a local port, up if something listens on it.
+func verifyWith(t *testing.T, up bool) ProbeConfig {
+	t.Helper()
+	port := closedPort(t, "tcp")
+	if up {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go func() {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				c.Close()
+			}
+		}()
+		port = l.Addr().(*net.TCPAddr).Port
+	}
+	return probeConfig(ProbeTCP, "127.0.0.1", port)
+}
+
+// playbookSite returns a swanctl site that logs into srv and runs steps.
+func playbookSite(t *testing.T, srv *sshStandIn, steps ...PlaybookStep) *Site {
+	withConfig(t, &Config{KnownHostsFile: filepath.Join(t.TempDir(), "known_hosts")})
+	r := RemediationConfig{Driver: DriverSwanctl, Connection: "hq-net"}
+	for i, st := range steps {
+		steps[i] = st.withDefaults(ProbeConfig{}, ICMPAuto)
+		if err := steps[i].validate("playbook", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Site{
+		TunnelConfig: TunnelConfig{Name: "hq", SSH: srv.target(), Remediation: r, Playbook: steps},
+		health:       NewHealth(defaultHealthConfig, time.Now()),
+		tun:          &fakeProber{name: "tun"},
+		driver:       r.RemediationDriver(),
+	}
+}
+
+// notes returns the text of every note queued in the outbox.
+func notes() []string {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	var texts []string
+	for _, op := range outbox.ops {
+		if op.Kind == OpNote {
+			texts = append(texts, op.Text)
+		}
+	}
+	return texts
+}
+
+func TestPlaybookEscalatesUntilUp(t *testing.T) {
+	rec := useTempStores(t)
+	srv := newSSHStandIn(t)
+	s := playbookSite(t, srv,
+		PlaybookStep{Action: ActionBounce, Wait: 10 * time.Millisecond, Timeout: 300 * time.Millisecond, Verify: verifyWith(t, false)},
+		PlaybookStep{Action: ActionService, Wait: 10 * time.Millisecond, Timeout: 2 * time.Second, Verify: verifyWith(t, true)},
+		PlaybookStep{Action: ActionReboot, Verify: verifyWith(t, true)},
+	)
+
+	if code := s.runPlaybook(t.Context()); code != 0 {
+		t.Fatalf("runPlaybook = %d, want 0 once the service restart brings the tunnel up", code)
+	}
+	want := []string{
+		"swanctl --terminate --child hq-net --force",
+		"swanctl --initiate --child hq-net --timeout 30",
+		"systemctl restart strongswan",
+	}
+	if got := srv.commands(); !slices.Equal(got, want) {
+		t.Errorf("device ran %q, want %q and no reboot", got, want)
+	}
+	n := notes()
+	if len(n) != 2 || !strings.Contains(n[0], "step 1/3") || !strings.Contains(n[0], "still down") || !strings.Contains(n[1], "confirmed up") {
+		t.Errorf("notes = %q, want step 1 still down, then step 2 confirmed up", n)
+	}
+	if inc := state.Get("hq").Incident; inc == nil || inc.RestartsSucceeded != 1 {
+		t.Errorf("incident = %+v, want one successful restart", inc)
+	}
+	if got := rec.count(EventRemediationFailed); got != 0 {
+		t.Errorf("sent %d remediation-failed notifications for a playbook that worked", got)
+	}
+}
+
+func TestPlaybookStopsOnFailedCommand(t *testing.T) {
+	rec := useTempStores(t)
+	srv := newSSHStandIn(t)
+	srv.exit = func(cmd string) uint32 {
+		if strings.Contains(cmd, "--initiate") {
+			return 1
+		}
+		return 0
+	}
+	s := playbookSite(t, srv,
+		PlaybookStep{Action: ActionBounce, StopOn: StopOnFailed, Verify: verifyWith(t, true)},
+		PlaybookStep{Action: ActionService, Verify: verifyWith(t, true)},
+	)
+
+	if code := s.runPlaybook(t.Context()); code != 4 {
+		t.Fatalf("runPlaybook = %d, want 4 for a failed command", code)
+	}
+	if got := srv.commands(); len(got) != 2 || slices.Contains(got, "systemctl restart strongswan") {
+		t.Errorf("device ran %q, want the bounce only", got)
+	}
+	if n := notes(); len(n) != 1 || !strings.Contains(n[0], "failed") {
+		t.Errorf("notes = %q, want the failed step", n)
+	}
+	if got := rec.count(EventRemediationFailed); got != 1 {
+		t.Errorf("sent %d remediation-failed notifications, want 1", got)
+	}
+}
@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	probing "github.com/prometheus-community/pro-bing"
+	"gopkg.in/yaml.v3"
+)
+
+// Probe types that can be used for any tunnel, WAN or device target.
+const (
+	ProbeICMP = "icmp"
+	ProbeTCP  = "tcp"
+	ProbeUDP  = "udp"
+	ProbeHTTP = "http"
+	ProbeDNS  = "dns"
+)
+
+// ICMP modes. Privileged sends raw ICMP and needs root or CAP_NET_RAW, unprivileged uses Linux UDP-ICMP
+// sockets and needs the process group inside net.ipv4.ping_group_range. Auto tries privileged first and
+// falls back to unprivileged once raw sockets turn out to be unavailable.
+const (
+	ICMPAuto         = "auto"
+	ICMPPrivileged   = "privileged"
+	ICMPUnprivileged = "unprivileged"
+)
+
+// Errors that mean pingo could not probe a target at all, as opposed to the target not answering.
+// They are wrapped in a ProbeError, so use errors.Is to tell them apart.
+var (
+	ErrResolve    = errors.New("could not resolve target")
+	ErrPermission = errors.New("not permitted to open probe socket")
+	ErrSocket     = errors.New("probe socket error")
+)
+
+// ProbeError is returned by Probe when the probe could not be run.
+type ProbeError struct {
+	Probe string // The probe's String()
+	Kind  error  // ErrResolve, ErrPermission or ErrSocket
+	Err   error  // The underlying error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("%s: %v: %v", e.Probe, e.Kind, e.Err)
+}
+
+func (e *ProbeError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// classifyNetErr wraps err in a ProbeError if it means the probe could not be run.
+// It returns nil for errors that just mean the target did not answer.
+func classifyNetErr(probe string, err error) error {
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &dnsErr):
+		return &ProbeError{Probe: probe, Kind: ErrResolve, Err: err}
+	case errors.Is(err, os.ErrPermission):
+		return &ProbeError{Probe: probe, Kind: ErrPermission, Err: err}
+	}
+	return nil
+}
+
+// ProbeConfig describes how to check a single target. In the config file a target can be a bare
+// address, which is probed with ICMP using the default settings, or a mapping with these fields.
+type ProbeConfig struct {
+	Address            string        `yaml:"address"`
+	Type               string        `yaml:"type"`               // icmp (default), tcp, udp, http or dns
+	Count              int           `yaml:"count"`              // Number of packets, connects, requests or queries per check
+	Interval           time.Duration `yaml:"interval"`           // Wait between each attempt
+	Timeout            time.Duration `yaml:"timeout"`            // The check gives up after this long, regardless of how many attempts were made
+	ICMPMode           string        `yaml:"icmpMode"`           // icmp: auto, privileged or unprivileged, defaults to the top-level icmpMode
+	Port               int           `yaml:"port"`               // tcp, udp: port to connect to. dns: server port, defaults to 53
+	Payload            string        `yaml:"payload"`            // udp: datagram to send, YAML escapes such as "\x00" allowed
+	Expect             string        `yaml:"expect"`             // udp: bytes the reply must contain, empty accepts any reply
+	URL                string        `yaml:"url"`                // http: defaults to http://<address>/
+	ExpectStatus       []int         `yaml:"expectStatus"`       // http: status codes that count as up, defaults to 200
+	InsecureSkipVerify bool          `yaml:"insecureSkipVerify"` // http: accept self-signed certificates on the far side
+	Query              string        `yaml:"query"`              // dns: name to resolve against the server at address
+	MaxLoss            float64       `yaml:"maxLoss"`            // Percent loss above which the target is degraded, 0 disables
+	MaxAvgRtt          time.Duration `yaml:"maxAvgRtt"`          // Average RTT above which the target is degraded, 0 disables
+	MaxJitter          time.Duration `yaml:"maxJitter"`          // RTT standard deviation above which the target is degraded, 0 disables
+}
+
+// UnmarshalYAML accepts either a bare address or a full probe mapping.
+func (pc *ProbeConfig) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&pc.Address)
+	}
+	type plain ProbeConfig
+	return n.Decode((*plain)(pc))
+}
+
+// withDefaults fills in the type and timing settings left unset in the config file.
+func (pc ProbeConfig) withDefaults(icmpMode string) ProbeConfig {
+	if pc.Type == "" {
+		pc.Type = ProbeICMP
+	}
+	if pc.ICMPMode == "" {
+		pc.ICMPMode = icmpMode
+	}
+	if pc.Count == 0 {
+		pc.Count = 10 // Count tells pinger to stop after sending (and receiving) 'c' echo packets.
+		if pc.Type != ProbeICMP {
+			pc.Count = 3
+		}
+	}
+	if pc.Interval == 0 {
+		pc.Interval = 2 * time.Second // Interval is the wait time between each packet send.
+	}
+	if pc.Timeout == 0 {
+		pc.Timeout = 30 * time.Second // Timeout specifies a timeout before ping exits, regardless of how many packets have been received.
+		if pc.Type != ProbeICMP {
+			pc.Timeout = 15 * time.Second
+		}
+	}
+	switch pc.Type {
+	case ProbeHTTP:
+		if pc.URL == "" {
+			pc.URL = "http://" + pc.Address + "/"
+		}
+		if len(pc.ExpectStatus) == 0 {
+			pc.ExpectStatus = []int{http.StatusOK}
+		}
+	case ProbeDNS:
+		if pc.Port == 0 {
+			pc.Port = 53
+		}
+	}
+	return pc
+}
+
+// validate checks that the probe has everything its type needs. name is used to prefix errors.
+func (pc ProbeConfig) validate(name string) error {
+	var errs []error
+	if pc.Address == "" {
+		errs = append(errs, fmt.Errorf("%s.address is required", name))
+	}
+	switch pc.Type {
+	case ProbeICMP:
+		if err := validateICMPMode(pc.ICMPMode); err != nil {
+			errs = append(errs, fmt.Errorf("%s.%w", name, err))
+		}
+	case ProbeHTTP:
+	case ProbeTCP, ProbeUDP:
+		if pc.Port == 0 {
+			errs = append(errs, fmt.Errorf("%s.port is required for %s probes", name, pc.Type))
+		}
+	case ProbeDNS:
+		if pc.Query == "" {
+			errs = append(errs, fmt.Errorf("%s.query is required for dns probes", name))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.type %q is not one of icmp, tcp, udp, http or dns", name, pc.Type))
+	}
+	if pc.Count < 1 || pc.Timeout <= 0 || pc.Interval < 0 {
+		errs = append(errs, fmt.Errorf("%s: count and timeout must be positive", name))
+	}
+	if pc.MaxLoss < 0 || pc.MaxLoss >= 100 || pc.MaxAvgRtt < 0 || pc.MaxJitter < 0 {
+		errs = append(errs, fmt.Errorf("%s: maxLoss must be between 0 and 100, maxAvgRtt and maxJitter must not be negative", name))
+	}
+	return errors.Join(errs...)
+}
+
+// validateICMPMode checks that mode is one of the ICMP modes.
+func validateICMPMode(mode string) error {
+	switch mode {
+	case ICMPAuto, ICMPPrivileged, ICMPUnprivileged:
+		return nil
+	}
+	return fmt.Errorf("icmpMode %q is not one of auto, privileged or unprivileged", mode)
+}
+
+// Prober builds the Prober described by pc. pc must already have been validated.
+func (pc ProbeConfig) Prober() Prober {
+	switch pc.Type {
+	case ProbeTCP:
+		return &TCPProber{cfg: pc}
+	case ProbeUDP:
+		return &UDPProber{cfg: pc}
+	case ProbeHTTP:
+		return &HTTPProber{cfg: pc, client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: pc.InsecureSkipVerify}},
+			// Redirects are reported as-is so expectStatus can match them
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}}
+	case ProbeDNS:
+		return &DNSProber{cfg: pc}
+	default:
+		return &ICMPProber{cfg: pc}
+	}
+}
+
+// ProbeStats summarises the attempts made by a single Probe call.
+type ProbeStats struct {
+	Sent      int
+	Recv      int
+	Loss      float64 // Percent of attempts that failed
+	MinRtt    time.Duration
+	AvgRtt    time.Duration
+	MaxRtt    time.Duration
+	StdDevRtt time.Duration
+}
+
+// Up reports whether the target answered at all.
+func (s ProbeStats) Up() bool {
+	return s.Recv > 0 && s.MaxRtt > 0
+}
+
+func (s ProbeStats) String() string {
+	return fmt.Sprintf("sent %d, received %d, loss %.1f%%, RTT min/avg/max/stddev %v/%v/%v/%v",
+		s.Sent, s.Recv, s.Loss, s.MinRtt, s.AvgRtt, s.MaxRtt, s.StdDevRtt)
+}
+
+// breach returns why stats exceed the loss, latency or jitter thresholds in pc, or "" if they don't.
+// A target that is down is not in breach, it is just down.
+func (pc ProbeConfig) breach(stats ProbeStats) string {
+	if !stats.Up() {
+		return ""
+	}
+	var reasons []string
+	if pc.MaxLoss > 0 && stats.Loss > pc.MaxLoss {
+		reasons = append(reasons, fmt.Sprintf("loss %.1f%% > %.1f%%", stats.Loss, pc.MaxLoss))
+	}
+	if pc.MaxAvgRtt > 0 && stats.AvgRtt > pc.MaxAvgRtt {
+		reasons = append(reasons, fmt.Sprintf("avg RTT %v > %v", stats.AvgRtt, pc.MaxAvgRtt))
+	}
+	if pc.MaxJitter > 0 && stats.StdDevRtt > pc.MaxJitter {
+		reasons = append(reasons, fmt.Sprintf("jitter %v > %v", stats.StdDevRtt, pc.MaxJitter))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// newProbeStats computes loss and RTT statistics the same way pro-bing does for ICMP.
+func newProbeStats(sent int, rtts []time.Duration) ProbeStats {
+	st := ProbeStats{Sent: sent, Recv: len(rtts)}
+	if sent > 0 {
+		st.Loss = float64(sent-len(rtts)) / float64(sent) * 100
+	}
+	if len(rtts) == 0 {
+		return st
+	}
+	st.MinRtt, st.MaxRtt = slices.Min(rtts), slices.Max(rtts)
+	var sum time.Duration
+	for _, r := range rtts {
+		sum += r
+	}
+	st.AvgRtt = sum / time.Duration(len(rtts))
+	var sqDiff float64
+	for _, r := range rtts {
+		d := float64(r - st.AvgRtt)
+		sqDiff += d * d
+	}
+	st.StdDevRtt = time.Duration(math.Sqrt(sqDiff / float64(len(rtts))))
+	return st
+}
+
+// Prober checks whether a single target is reachable.
+type Prober interface {
+	// Probe runs one check against the target and returns what it saw. A non-nil error is a
+	// *ProbeError and means the probe could not be run, so the stats say nothing about the target.
+	Probe(ctx context.Context) (ProbeStats, error)
+	// String describes the probe for logging, e.g. "tcp 10.0.0.1:443".
+	String() string
+}
+
+// repeatProbe runs attempt cfg.Count times, cfg.Interval apart, until cfg.Timeout expires and
+// collects the round-trip time of every attempt that returned no error. An attempt returning a
+// *ProbeError stops the probe and the error is passed back to the caller.
+func repeatProbe(ctx context.Context, cfg ProbeConfig, attempt func(ctx context.Context) error) (ProbeStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+	perAttempt := cfg.Timeout / time.Duration(cfg.Count)
+
+	var rtts []time.Duration
+	sent := 0
+	for n := range cfg.Count {
+		if n > 0 {
+			select {
+			case <-ctx.Done():
+				return newProbeStats(sent, rtts), nil
+			case <-time.After(cfg.Interval):
+			}
+		}
+		attemptCtx, attemptCancel := context.WithTimeout(ctx, perAttempt)
+		start := time.Now()
+		err := attempt(attemptCtx)
+		rtt := time.Since(start)
+		attemptCancel()
+		sent++
+		var probeErr *ProbeError
+		if errors.As(err, &probeErr) {
+			return ProbeStats{}, err
+		}
+		if err == nil {
+			rtts = append(rtts, rtt)
+		}
+	}
+	return newProbeStats(sent, rtts), nil
+}
+
+// rawICMPUnavailable is set the first time a privileged ping is refused in auto mode, so later
+// probes go straight to unprivileged mode.
+var rawICMPUnavailable atomic.Bool
+
+// ICMPProber pings the target with pro-bing.
+type ICMPProber struct {
+	cfg ProbeConfig
+}
+
+func (p *ICMPProber) String() string { return "icmp " + p.cfg.Address }
+
+// Probe pings the target and returns the pinger's statistics. In auto mode a permission error on
+// the raw socket falls back to an unprivileged UDP-ICMP socket.
+func (p *ICMPProber) Probe(ctx context.Context) (ProbeStats, error) {
+	privileged := p.cfg.ICMPMode == ICMPPrivileged || (p.cfg.ICMPMode == ICMPAuto && !rawICMPUnavailable.Load())
+	stats, err := p.ping(ctx, privileged)
+	if privileged && p.cfg.ICMPMode == ICMPAuto && errors.Is(err, ErrPermission) {
+		if !rawICMPUnavailable.Swap(true) {
+			AddtoLog(fmt.Sprintf("Raw ICMP sockets are not permitted (%v). Falling back to unprivileged ICMP.", err))
+		}
+		stats, err = p.ping(ctx, false)
+	}
+	return stats, err
+}
+
+// ping runs a single pinger in the given mode.
+func (p *ICMPProber) ping(ctx context.Context, privileged bool) (ProbeStats, error) {
+	pinger, err := probing.NewPinger(p.cfg.Address)
+	if err != nil {
+		return ProbeStats{}, &ProbeError{Probe: p.String(), Kind: ErrResolve, Err: err}
+	}
+
+	pinger.SetPrivileged(privileged)
+	pinger.SetLogger(probing.NoopLogger{})
+	pinger.Count = p.cfg.Count
+	pinger.Interval = p.cfg.Interval
+	pinger.Timeout = p.cfg.Timeout
+	if err := pinger.RunWithContext(ctx); err != nil && ctx.Err() == nil {
+		kind := ErrSocket
+		if errors.Is(err, os.ErrPermission) {
+			kind = ErrPermission
+		}
+		return ProbeStats{}, &ProbeError{Probe: p.String(), Kind: kind, Err: err}
+	}
+
+	stats := pinger.Statistics() // get send/receive/rtt stats
+	return ProbeStats{
+		Sent:      stats.PacketsSent,
+		Recv:      stats.PacketsRecv,
+		Loss:      stats.PacketLoss,
+		MinRtt:    stats.MinRtt,
+		AvgRtt:    stats.AvgRtt,
+		MaxRtt:    stats.MaxRtt,
+		StdDevRtt: stats.StdDevRtt,
+	}, nil
+}
+
+// TCPProber opens a TCP connection to the target port, for firewalls that drop ICMP across the tunnel.
+type TCPProber struct {
+	cfg ProbeConfig
+}
+
+func (p *TCPProber) String() string {
+	return "tcp " + net.JoinHostPort(p.cfg.Address, strconv.Itoa(p.cfg.Port))
+}
+
+// Probe counts every completed TCP handshake as a received reply.
+func (p *TCPProber) Probe(ctx context.Context) (ProbeStats, error) {
+	addr := net.JoinHostPort(p.cfg.Address, strconv.Itoa(p.cfg.Port))
+	return repeatProbe(ctx, p.cfg, func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			if probeErr := classifyNetErr(p.String(), err); probeErr != nil {
+				return probeErr
+			}
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// UDPProber sends a datagram to the target port and waits for a reply, for UDP services such as
+// SIP, RADIUS or NTP on the far side of the tunnel.
+type UDPProber struct {
+	cfg ProbeConfig
+}
+
+func (p *UDPProber) String() string {
+	return "udp " + net.JoinHostPort(p.cfg.Address, strconv.Itoa(p.cfg.Port))
+}
+
+// Probe counts every reply that contains the expected bytes as a received reply. A port unreachable
+// from the target counts as no reply.
+func (p *UDPProber) Probe(ctx context.Context) (ProbeStats, error) {
+	addr := net.JoinHostPort(p.cfg.Address, strconv.Itoa(p.cfg.Port))
+	return repeatProbe(ctx, p.cfg, func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "udp", addr)
+		if err != nil {
+			if probeErr := classifyNetErr(p.String(), err); probeErr != nil {
+				return probeErr
+			}
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		if _, err := conn.Write([]byte(p.cfg.Payload)); err != nil {
+			return err
+		}
+		buf := make([]byte, 64*1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(buf[:n], []byte(p.cfg.Expect)) {
+			return fmt.Errorf("reply does not contain %q", p.cfg.Expect)
+		}
+		return nil
+	})
+}
+
+// HTTPProber sends a GET request to the target and checks the response status.
+type HTTPProber struct {
+	cfg    ProbeConfig
+	client *http.Client
+}
+
+func (p *HTTPProber) String() string { return "http " + p.cfg.URL }
+
+// Probe counts every response with an expected status as a received reply.
+func (p *HTTPProber) Probe(ctx context.Context) (ProbeStats, error) {
+	return repeatProbe(ctx, p.cfg, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.URL, nil)
+		if err != nil {
+			return &ProbeError{Probe: p.String(), Kind: ErrSocket, Err: err}
+		}
+		res, err := p.client.Do(req)
+		if err != nil {
+			if probeErr := classifyNetErr(p.String(), err); probeErr != nil {
+				return probeErr
+			}
+			return err
+		}
+		res.Body.Close()
+		if !slices.Contains(p.cfg.ExpectStatus, res.StatusCode) {
+			return fmt.Errorf("unexpected status %s", res.Status)
+		}
+		return nil
+	})
+}
+
+// DNSProber resolves a name against a DNS server on the far side of the tunnel.
+type DNSProber struct {
+	cfg ProbeConfig
+}
+
+func (p *DNSProber) String() string {
+	return fmt.Sprintf("dns %s@%s", p.cfg.Query, net.JoinHostPort(p.cfg.Address, strconv.Itoa(p.cfg.Port)))
+}
+
+// Probe counts every answer from the server as a received reply. NXDOMAIN still proves the server is reachable.
+func (p *DNSProber) Probe(ctx context.Context) (ProbeStats, error) {
+	server := net.JoinHostPort(p.cfg.Address, strconv.Itoa(p.cfg.Port))
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+	return repeatProbe(ctx, p.cfg, func(ctx context.Context) error {
+		_, err := resolver.LookupHost(ctx, p.cfg.Query)
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// probeConfig returns a quick probe of typ against addr, with defaults filled in.
+func probeConfig(typ, addr string, port int) ProbeConfig {
+	return ProbeConfig{Type: typ, Address: addr, Port: port, Count: 2, Interval: 10 * time.Millisecond, Timeout: 2 * time.Second}.withDefaults(ICMPAuto)
+}
+
+// probe runs pc and fails the test if the probe could not be run.
+func probe(t *testing.T, pc ProbeConfig) ProbeStats {
+	t.Helper()
+	if err := pc.validate("probe"); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := pc.Prober().Probe(context.Background())
+	if err != nil {
+		t.Fatalf("%s: %v", pc.Prober(), err)
+	}
+	return stats
+}
+
+// closedPort returns a local port nothing listens on.
+func closedPort(t *testing.T, network string) int {
+	t.Helper()
+	var addr net.Addr
+	if network == "tcp" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = l.Addr()
+		l.Close()
+	} else {
+		c, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = c.LocalAddr()
+		c.Close()
+	}
+	_, port, _ := net.SplitHostPort(addr.String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// serveUDP answers every datagram on a local port with reply(datagram) and returns the port.
+func serveUDP(t *testing.T, reply func([]byte) []byte) int {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			c.WriteTo(reply(buf[:n]), from)
+		}
+	}()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestTCPProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	if s := probe(t, probeConfig(ProbeTCP, "127.0.0.1", l.Addr().(*net.TCPAddr).Port)); !s.Up() || s.Sent != 2 || s.Recv != 2 || s.Loss != 0 {
+		t.Errorf("listening port: %s, want every connect to succeed", s)
+	}
+	if s := probe(t, probeConfig(ProbeTCP, "127.0.0.1", closedPort(t, "tcp"))); s.Up() || s.Sent != 2 || s.Loss != 100 {
+		t.Errorf("closed port: %s, want down", s)
+	}
+}
+
+func TestUDPProbe(t *testing.T) {
+	echo := serveUDP(t, func(b []byte) []byte { return append([]byte("pong "), b...) })
+
+	pc := probeConfig(ProbeUDP, "127.0.0.1", echo)
+	pc.Payload, pc.Expect = "ping\x00", "pong"
+	if s := probe(t, pc); !s.Up() || s.Recv != 2 {
+		t.Errorf("echo with the expected reply: %s, want up", s)
+	}
+	pc.Expect = "hello"
+	if s := probe(t, pc); s.Up() {
+		t.Errorf("reply without the expected bytes: %s, want down", s)
+	}
+	pc = probeConfig(ProbeUDP, "127.0.0.1", closedPort(t, "udp"))
+	pc.Timeout = 200 * time.Millisecond
+	if s := probe(t, pc); s.Up() {
+		t.Errorf("closed port: %s, want down", s)
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.WriteHeader(http.StatusOK)
+		case "/login":
+			http.Redirect(w, r, "/sso", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	if s := probe(t, probeConfig(ProbeHTTP, u.Host, 0)); !s.Up() || s.Recv != 2 {
+		t.Errorf("GET / answering 200: %s, want up with the default url and expectStatus", s)
+	}
+	pc := probeConfig(ProbeHTTP, u.Host, 0)
+	pc.URL = srv.URL + "/missing"
+	if s := probe(t, pc); s.Up() {
+		t.Errorf("404: %s, want down", s)
+	}
+	pc.URL, pc.ExpectStatus = srv.URL+"/login", []int{http.StatusFound}
+	if s := probe(t, pc); !s.Up() {
+		t.Errorf("redirect with expectStatus 302: %s, want up without following it", s)
+	}
+}
+
+func TestDNSProbe(t *testing.T) {
+	// Answers every query with NXDOMAIN, which still proves the server is reachable
+	nxdomain := serveUDP(t, func(q []byte) []byte {
+		r := append([]byte(nil), q...)
+		r[2] |= 0x80    // QR: this is a response
+		r[3] = 0x80 | 3 // RA, RCODE NXDOMAIN
+		return r
+	})
+	pc := probeConfig(ProbeDNS, "127.0.0.1", nxdomain)
+	pc.Query = "hq.pingo.invalid."
+	if s := probe(t, pc); !s.Up() {
+		t.Errorf("NXDOMAIN answer: %s, want up", s)
+	}
+	pc.Port, pc.Timeout = closedPort(t, "udp"), 300*time.Millisecond
+	if s := probe(t, pc); s.Up() {
+		t.Errorf("no server: %s, want down", s)
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	_, err := probeConfig(ProbeTCP, "hq.pingo.invalid", 443).Prober().Probe(context.Background())
+	var pe *ProbeError
+	if !errors.As(err, &pe) || !errors.Is(err, ErrResolve) {
+		t.Errorf("unresolvable target returned %v, want a ProbeError wrapping ErrResolve", err)
+	}
+	if err := probeConfig(ProbeTCP, "10.0.0.1", 0).validate("tun"); err == nil {
+		t.Error("tcp probe without a port passed validation")
+	}
+	if err := probeConfig("smoke", "10.0.0.1", 0).validate("tun"); err == nil {
+		t.Error("unknown probe type passed validation")
+	}
+}
+
+func TestProbeStatsAndBreach(t *testing.T) {
+	ms := time.Millisecond
+	s := newProbeStats(4, []time.Duration{10 * ms, 20 * ms, 30 * ms})
+	if s.Loss != 25 || s.MinRtt != 10*ms || s.MaxRtt != 30*ms || s.AvgRtt != 20*ms || s.StdDevRtt != 8164965 {
+		t.Errorf("newProbeStats = %s", s)
+	}
+	pc := ProbeConfig{MaxLoss: 10, MaxAvgRtt: 15 * ms, MaxJitter: time.Second}
+	if got, want := pc.breach(s), "loss 25.0% > 10.0%, avg RTT 20ms > 15ms"; got != want {
+		t.Errorf("breach = %q, want %q", got, want)
+	}
+	if got := pc.breach(newProbeStats(4, nil)); got != "" {
+		t.Errorf("breach of a target that is down = %q, want none", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Recovery actions, taken on the incident's ticket once the tunnel is Up again.
+const (
+	RecoveryNone    = "none"    // Leave the ticket alone
+	RecoveryNote    = "note"    // Add a resolution note with the outage duration and the remediation taken
+	RecoveryResolve = "resolve" // Add the note and move the ticket to RecoveryConfig.Status
+)
+
+// RecoveryConfig decides what pingo does with a tunnel's ticket when the tunnel recovers, whether by itself or after a restart.
+type RecoveryConfig struct {
+	Action string `yaml:"action"` // none, note or resolve. Defaults to note
+	Status int    `yaml:"status"` // Status the ticket is moved to by resolve, e.g. Resolved
+}
+
+// defaultRecoveryConfig is used for anything not set in the top-level recovery section.
+var defaultRecoveryConfig = RecoveryConfig{Action: RecoveryNote}
+
+// withDefaults returns r with every unset field taken from def.
+func (r RecoveryConfig) withDefaults(def RecoveryConfig) RecoveryConfig {
+	if r.Action == "" {
+		r.Action = def.Action
+	}
+	if r.Status == 0 {
+		r.Status = def.Status
+	}
+	return r
+}
+
+// validate checks r, naming it prefix in errors. Only Manage needs a status to resolve a ticket.
+func (r RecoveryConfig) validate(prefix string, manage bool) error {
+	switch r.Action {
+	case RecoveryNone, RecoveryNote:
+		return nil
+	case RecoveryResolve:
+		if manage && r.Status == 0 {
+			return fmt.Errorf("%s.status is required when action is resolve", prefix)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s.action must be none, note or resolve, got %q", prefix, r.Action)
+}
+
+// recovered annotates and, if configured, resolves the ticket of an incident that just ended.
+func (s *Site) recovered(inc Incident, now time.Time) {
+	took := now.Sub(inc.FirstSeen).Round(time.Second)
+	s.Log(fmt.Sprintf("Tunnel recovered after %s. Closing the incident for ticket %s that started %s", took, inc.TicketID, inc.FirstSeen.Format(time.DateTime)))
+	if s.Recovery.Action == RecoveryNone {
+		return
+	}
+	if inc.TicketID == "" && !outbox.createPending(s.Name, inc.ID) {
+		return // No ticket was opened for this incident
+	}
+
+	remediation := "No remediation was needed, the tunnel recovered on its own."
+	if inc.RestartAttempts > 0 {
+		remediation = fmt.Sprintf("pingo restarted the tunnel %d times, %d of which brought it back up.", inc.RestartAttempts, inc.RestartsSucceeded)
+	}
+	note := fmt.Sprintf("Tunnel recovered at %s after an outage of %s (since %s). %s",
+		now.Format(time.DateTime), took, inc.FirstSeen.Format(time.DateTime), remediation)
+	s.queueFor(inc, OutboxOp{Kind: OpNote, Text: note, Resolution: true})
+	if s.Recovery.Action == RecoveryResolve {
+		s.queueFor(inc, OutboxOp{Kind: OpResolve, StatusID: s.Recovery.Status})
+	}
+}
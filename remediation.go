@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// Remediation drivers.
+const (
+	DriverIPsec     = "ipsec"     // strongSwan's or Openswan's ipsec script (default)
+	DriverSwanctl   = "swanctl"   // strongSwan with swanctl.conf
+	DriverLibreswan = "libreswan" // Libreswan's ipsec auto
+	DriverPfSense   = "pfsense"   // pfSense or OPNsense shell
+	DriverRouterOS  = "routeros"  // MikroTik RouterOS CLI
+	DriverFortiGate = "fortigate" // FortiGate CLI
+	DriverCiscoIOS  = "cisco-ios" // Cisco IOS and IOS XE CLI
+	DriverCiscoASA  = "cisco-asa" // Cisco ASA CLI
+)
+
+// remediationDrivers lists every driver.
+var remediationDrivers = []string{DriverIPsec, DriverSwanctl, DriverLibreswan, DriverPfSense, DriverRouterOS, DriverFortiGate, DriverCiscoIOS, DriverCiscoASA}
+
+// connectionName matches the connection names pingo will put in a command line, so they never need quoting.
+var connectionName = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// RemediationConfig picks how pingo restarts a tunnel on its device. Every driver except ipsec without a
+// connection bounces only this tunnel, leaving the device's other tunnels alone.
+type RemediationConfig struct {
+	Driver     string `yaml:"driver"`     // One of remediationDrivers, defaults to ipsec
+	Connection string `yaml:"connection"` // ipsec, libreswan: conn name. swanctl: child SA. pfsense: phase 1 (con<N> on pfSense, the UUID on OPNsense). fortigate: phase 1 interface
+	Child      string `yaml:"child"`      // pfsense: child SA to initiate, defaults to every child of the connection. fortigate: phase 2 to bring up
+	Peer       string `yaml:"peer"`       // routeros, cisco-ios, cisco-asa: remote peer IP
+}
+
+func (r RemediationConfig) validate(prefix string) error {
+	var errs []error
+	needs := func(field, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s.%s is required for the %s driver", prefix, field, r.Driver))
+		}
+	}
+	switch r.Driver {
+	case DriverIPsec:
+	case DriverSwanctl, DriverLibreswan, DriverPfSense, DriverFortiGate:
+		needs("connection", r.Connection)
+	case DriverRouterOS, DriverCiscoIOS, DriverCiscoASA:
+		needs("peer", r.Peer)
+	default:
+		errs = append(errs, fmt.Errorf("%s.driver must be one of %s, got %q", prefix, strings.Join(remediationDrivers, ", "), r.Driver))
+	}
+	for _, f := range []struct{ field, value string }{{"connection", r.Connection}, {"child", r.Child}} {
+		if f.value != "" && !connectionName.MatchString(f.value) {
+			errs = append(errs, fmt.Errorf("%s.%s %q may only contain letters, digits and _.:-", prefix, f.field, f.value))
+		}
+	}
+	if r.Peer != "" && net.ParseIP(r.Peer) == nil {
+		errs = append(errs, fmt.Errorf("%s.peer %q is not an IP address", prefix, r.Peer))
+	}
+	return errors.Join(errs...)
+}
+
+// DeviceCommand is one command a driver runs on the device.
+type DeviceCommand struct {
+	Cmd     string
+	MayFail bool // A failure is logged and ignored, e.g. tearing down an SA that's already gone or a reboot dropping the connection
+}
+
+// RemediationDriver restarts a tunnel on one kind of device over SSH. Each method returns commands that are
+// run in order until one fails.
+type RemediationDriver interface {
+	// Bounce tears down and re-initiates just this tunnel.
+	Bounce() []DeviceCommand
+	// RestartService restarts the device's IPsec service, dropping every tunnel on it.
+	RestartService() []DeviceCommand
+	// Reboot reboots the device, or returns nil if its CLI can't without answering a confirmation prompt.
+	Reboot() []DeviceCommand
+	// Shell reports whether the commands must be typed into an interactive shell, for devices that
+	// can't run them as separate exec requests.
+	Shell() bool
+	// Failed returns an error if output shows the device rejected a command. Network CLIs exit 0 regardless.
+	Failed(output string) error
+	String() string
+}
+
+// RemediationDriver builds the driver described by r. r must already have been validated.
+func (r RemediationConfig) RemediationDriver() RemediationDriver {
+	switch r.Driver {
+	case DriverSwanctl:
+		return swanctlDriver{child: r.Connection}
+	case DriverLibreswan:
+		return libreswanDriver{conn: r.Connection}
+	case DriverPfSense:
+		return pfSenseDriver{ike: r.Connection, child: r.Child}
+	case DriverRouterOS:
+		return routerOSDriver{cliErrors: routerOSErrors, peer: r.Peer}
+	case DriverFortiGate:
+		return fortiGateDriver{cliErrors: fortiGateErrors, phase1: r.Connection, phase2: r.Child}
+	case DriverCiscoIOS:
+		return ciscoIOSDriver{cliErrors: ciscoErrors, peer: r.Peer}
+	case DriverCiscoASA:
+		return ciscoASADriver{cliErrors: ciscoErrors, peer: r.Peer}
+	default:
+		return ipsecDriver{conn: r.Connection}
+	}
+}
+
+// unixShell is embedded by drivers for devices with a Unix shell, where a failed command exits non-zero.
+type unixShell struct{}
+
+func (unixShell) Shell() bool         { return false }
+func (unixShell) Failed(string) error { return nil }
+
+func (unixShell) Reboot() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "shutdown -r now", MayFail: true}}
+}
+
+// cliErrors finds the lines a network CLI prints when it rejects a command.
+type cliErrors []string
+
+func (prefixes cliErrors) Failed(output string) error {
+	for line := range strings.Lines(output) {
+		line = strings.TrimSpace(line)
+		for _, p := range prefixes {
+			if strings.HasPrefix(line, p) {
+				return fmt.Errorf("the device rejected the command: %s", line)
+			}
+		}
+	}
+	return nil
+}
+
+// The starts of the lines each CLI prints when it rejects a command.
+var (
+	routerOSErrors  = cliErrors{"syntax error", "bad command name", "expected end of command", "input does not match", "no such item", "failure:"}
+	fortiGateErrors = cliErrors{"Command fail", "Unknown action", "command parse error"}
+	ciscoErrors     = cliErrors{"% ", "ERROR:"}
+)
+
+// ipsecDriver restarts one conn with ipsec down/up, or the whole IPsec service if no conn is configured.
+type ipsecDriver struct {
+	unixShell
+	conn string
+}
+
+func (d ipsecDriver) Bounce() []DeviceCommand {
+	if d.conn == "" {
+		return []DeviceCommand{{Cmd: "ipsec restart"}}
+	}
+	return []DeviceCommand{{Cmd: "ipsec down " + d.conn, MayFail: true}, {Cmd: "ipsec up " + d.conn}}
+}
+
+func (ipsecDriver) RestartService() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "ipsec restart"}}
+}
+
+func (d ipsecDriver) String() string {
+	if d.conn == "" {
+		return "ipsec restart"
+	}
+	return "ipsec conn " + d.conn
+}
+
+// swanctlDriver terminates and re-initiates a single strongSwan child SA.
+type swanctlDriver struct {
+	unixShell
+	child string
+}
+
+func (d swanctlDriver) Bounce() []DeviceCommand {
+	return []DeviceCommand{
+		{Cmd: "swanctl --terminate --child " + d.child + " --force", MayFail: true},
+		{Cmd: "swanctl --initiate --child " + d.child + " --timeout 30"},
+	}
+}
+
+func (swanctlDriver) RestartService() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "systemctl restart strongswan"}}
+}
+
+func (d swanctlDriver) String() string { return "swanctl child " + d.child }
+
+// libreswanDriver takes a Libreswan conn down and back up.
+type libreswanDriver struct {
+	unixShell
+	conn string
+}
+
+func (d libreswanDriver) Bounce() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "ipsec auto --down " + d.conn, MayFail: true}, {Cmd: "ipsec auto --up " + d.conn}}
+}
+
+func (libreswanDriver) RestartService() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "ipsec restart"}}
+}
+
+func (d libreswanDriver) String() string { return "libreswan conn " + d.conn }
+
+// pfSenseDriver terminates and re-initiates a phase 1 through the swanctl that pfSense and OPNsense ship.
+// Logging in as root or admin runs commands in the shell rather than the console menu.
+type pfSenseDriver struct {
+	unixShell
+	ike, child string
+}
+
+func (d pfSenseDriver) Bounce() []DeviceCommand {
+	initiate := "/usr/local/sbin/swanctl --initiate --ike " + d.ike + " --timeout 30"
+	if d.child != "" {
+		initiate += " --child " + d.child
+	}
+	return []DeviceCommand{{Cmd: "/usr/local/sbin/swanctl --terminate --ike " + d.ike + " --force", MayFail: true}, {Cmd: initiate}}
+}
+
+// RestartService uses configctl on OPNsense and pfSsh.php on pfSense, so the service's config is rebuilt the way the GUI would.
+func (pfSenseDriver) RestartService() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "if [ -x /usr/local/sbin/configctl ]; then /usr/local/sbin/configctl ipsec restart; else /usr/local/sbin/pfSsh.php playback svc restart ipsec; fi"}}
+}
+
+func (d pfSenseDriver) String() string { return "pfSense/OPNsense phase 1 " + d.ike }
+
+// routerOSDriver drops the active peer so RouterOS negotiates new SAs with it.
+type routerOSDriver struct {
+	cliErrors
+	peer string
+}
+
+func (d routerOSDriver) Bounce() []DeviceCommand {
+	return []DeviceCommand{{Cmd: fmt.Sprintf(`/ip ipsec active-peers remove [find remote-address="%s"]`, d.peer)}}
+}
+
+func (routerOSDriver) RestartService() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "/ip ipsec active-peers kill-connections"}}
+}
+
+func (routerOSDriver) Reboot() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "/system reboot", MayFail: true}} // Exec requests don't ask for confirmation
+}
+
+func (routerOSDriver) Shell() bool      { return false }
+func (d routerOSDriver) String() string { return "RouterOS peer " + d.peer }
+
+// fortiGateDriver clears a phase 1 gateway, then brings the phase 2 up if one is configured.
+type fortiGateDriver struct {
+	cliErrors
+	phase1, phase2 string
+}
+
+func (d fortiGateDriver) Bounce() []DeviceCommand {
+	cmds := []DeviceCommand{{Cmd: "diagnose vpn ike gateway clear name " + d.phase1}}
+	if d.phase2 != "" {
+		cmds = append(cmds, DeviceCommand{Cmd: "diagnose vpn tunnel up " + d.phase2 + " " + d.phase1})
+	}
+	return cmds
+}
+
+func (fortiGateDriver) RestartService() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "diagnose vpn ike restart"}}
+}
+
+func (fortiGateDriver) Reboot() []DeviceCommand { return nil } // execute reboot asks for confirmation
+
+func (fortiGateDriver) Shell() bool      { return false }
+func (d fortiGateDriver) String() string { return "FortiGate phase 1 " + d.phase1 }
+
+// ciscoIOSDriver clears the SAs with one peer. The user needs privilege 15, since clear is an enable command.
+type ciscoIOSDriver struct {
+	cliErrors
+	peer string
+}
+
+func (d ciscoIOSDriver) Bounce() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "clear crypto sa peer " + d.peer}}
+}
+
+func (ciscoIOSDriver) RestartService() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "clear crypto session"}}
+}
+
+func (ciscoIOSDriver) Reboot() []DeviceCommand { return nil } // reload asks for confirmation
+
+func (ciscoIOSDriver) Shell() bool      { return false }
+func (d ciscoIOSDriver) String() string { return "Cisco IOS peer " + d.peer }
+
+// ciscoASADriver clears the SAs with one peer. The ASA doesn't take exec requests, so the commands are typed
+// into its shell, and the user must land in privileged mode (aaa authorization exec LOCAL auto-enable).
+type ciscoASADriver struct {
+	cliErrors
+	peer string
+}
+
+func (d ciscoASADriver) Bounce() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "terminal pager 0"}, {Cmd: "clear crypto ipsec sa peer " + d.peer}}
+}
+
+func (ciscoASADriver) RestartService() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "clear crypto ipsec sa"}, {Cmd: "clear crypto ikev1 sa"}, {Cmd: "clear crypto ikev2 sa"}}
+}
+
+func (ciscoASADriver) Reboot() []DeviceCommand {
+	return []DeviceCommand{{Cmd: "reload noconfirm", MayFail: true}}
+}
+
+func (ciscoASADriver) Shell() bool      { return true }
+func (d ciscoASADriver) String() string { return "Cisco ASA peer " + d.peer }
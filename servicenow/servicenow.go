@@ -0,0 +1,110 @@
+// Package servicenow is a small client for the ServiceNow Table API.
+package servicenow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"pingo/internal/httpapi"
+)
+
+// Errors matched by errors.Is against anything the client returns.
+var (
+	ErrNotFound     = httpapi.ErrNotFound
+	ErrUnauthorized = httpapi.ErrUnauthorized
+)
+
+// Config holds everything needed to talk to a ServiceNow instance.
+type Config struct {
+	URL      string // e.g. https://acme.service-now.com
+	User     string
+	Password string
+	Timeout  time.Duration // Per-request timeout, defaults to 30s
+}
+
+// Client calls the Table API. It is safe for concurrent use.
+type Client struct {
+	api *httpapi.Client
+}
+
+// NewClient returns a Client for the instance described by c.
+func NewClient(c Config) *Client {
+	return &Client{api: httpapi.New("servicenow", c.URL+"/api/now/table", c.Timeout, func(r *http.Request) {
+		r.SetBasicAuth(c.User, c.Password)
+	})}
+}
+
+// Record is a table record as the Table API returns it with sysparm_display_value=false.
+// Plain fields come back as strings and reference fields (caller_id, assignment_group, ...) as their sys_id,
+// or as a {"link", "value"} object from an instance that ignores sysparm_exclude_reference_link.
+type Record map[string]any
+
+// Field returns the value of a field, or of a reference field the sys_id it points to.
+func (r Record) Field(name string) string {
+	switch v := r[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]any:
+		s, _ := v["value"].(string)
+		return s
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// SysID returns the record's sys_id.
+func (r Record) SysID() string { return r.Field("sys_id") }
+
+// params returns the query parameters sent with every request: reference fields come back as plain sys_ids.
+func params() url.Values {
+	q := url.Values{}
+	q.Set("sysparm_exclude_reference_link", "true")
+	return q
+}
+
+// Create inserts a record into table and returns it.
+func (c *Client) Create(ctx context.Context, table string, fields map[string]any) (Record, error) {
+	var out struct {
+		Result Record `json:"result"`
+	}
+	if err := c.api.Do(ctx, http.MethodPost, "/"+url.PathEscape(table), params(), fields, &out); err != nil {
+		return nil, err
+	}
+	return out.Result, nil
+}
+
+// Get fetches a record by sys_id.
+func (c *Client) Get(ctx context.Context, table, sysID string) (Record, error) {
+	var out struct {
+		Result Record `json:"result"`
+	}
+	if err := c.api.Do(ctx, http.MethodGet, "/"+url.PathEscape(table)+"/"+url.PathEscape(sysID), params(), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Result, nil
+}
+
+// Update sets fields on a record. Writing work_notes or comments adds a journal entry rather than replacing it.
+func (c *Client) Update(ctx context.Context, table, sysID string, fields map[string]any) error {
+	return c.api.Do(ctx, http.MethodPatch, "/"+url.PathEscape(table)+"/"+url.PathEscape(sysID), params(), fields, nil)
+}
+
+// Query returns up to limit records of table matching an encoded query, e.g. `active=true^short_descriptionLIKEhq`.
+func (c *Client) Query(ctx context.Context, table, query string, limit int) ([]Record, error) {
+	q := params()
+	q.Set("sysparm_query", query)
+	q.Set("sysparm_limit", strconv.Itoa(limit))
+	var out struct {
+		Result []Record `json:"result"`
+	}
+	if err := c.api.Do(ctx, http.MethodGet, "/"+url.PathEscape(table), q, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Result, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Site is one monitored tunnel along with the decision state kept between its checks.
+// Each Site runs in its own goroutine, so nothing here is shared with other sites.
+type Site struct {
+	TunnelConfig
+	health *Health
+	tun    Prober
+	wan    Prober
+	dev    Prober
+	driver RemediationDriver
+}
+
+// NewSite creates a Site for the given tunnel definition. The tunnel picks up the health state it was
+// last in according to the state store, or starts out Up.
+func NewSite(t TunnelConfig) *Site {
+	s := &Site{health: NewHealth(t.Health, time.Now())}
+	if ts := state.Get(t.Name); ts.LastState != "" {
+		s.health.State, s.health.Since = parseHealthState(ts.LastState), ts.LastChange
+		s.health.Downs = ts.Downs
+	}
+	s.Reconfigure(t)
+	return s
+}
+
+// Reconfigure swaps in a reloaded tunnel definition while keeping the site's health state.
+func (s *Site) Reconfigure(t TunnelConfig) {
+	s.TunnelConfig = t
+	s.health.Config = t.Health
+	s.tun = t.Tun.Prober()
+	s.wan = t.Wan.Prober()
+	s.dev = t.Dev.Prober()
+	s.driver = t.Remediation.RemediationDriver()
+}
+
+// Log writes a message to pingo.log prefixed with the site name so concurrent sites stay distinguishable.
+func (s *Site) Log(msg string) {
+	siteLog(s.Name, msg)
+}
+
+// siteLog writes a message to pingo.log prefixed with a site name, for code that runs outside the site's goroutine.
+func siteLog(name, msg string) {
+	AddtoLog(fmt.Sprintf("[%s] %s", name, msg))
+}
+
+// test runs p and returns its statistics, logging them whenever a target that is otherwise up shows loss.
+// A non-nil error means the probe could not be run, and the result says nothing about the target.
+func (s *Site) test(ctx context.Context, p Prober) (ProbeStats, error) {
+	stats, err := p.Probe(ctx)
+	if err != nil {
+		s.Log(fmt.Sprintf("Could not probe %v", err))
+		return stats, err
+	}
+	if stats.Up() && stats.Loss > 0 {
+		s.Log(fmt.Sprintf("Probe %s reveals packet loss: %s", p, stats))
+	}
+	return stats, nil
+}
+
+// Check tests the tunnel once and feeds the result into the site's health state machine.
+// Remediation only runs while the tunnel is Down. Degraded and Recovering wait for more checks, and
+// Flapping opens a single ticket and leaves the tunnel alone until it settles.
+// The returned code is 0 when no action was needed or the restart succeeded, and matches the exit codes of `pingo check` otherwise.
+// A probe that could not be run is not a verdict on the tunnel, so it leaves the health state alone and returns 6.
+func (s *Site) Check(ctx context.Context) int {
+	stats, err := s.test(ctx, s.tun)
+	if ctx.Err() != nil {
+		return 0 // Shutting down, the probe was cut short
+	}
+	if err != nil {
+		return 6
+	}
+	now := time.Now()
+	tr, changed := s.health.Observe(stats.Up(), s.Tun.breach(stats), now)
+	if changed {
+		s.Log(tr.String())
+	}
+	s.recordState(now)
+	if changed {
+		switch tr.To {
+		case StateDown:
+			s.alert(EventDown, fmt.Sprintf("Tunnel %s is down: %s.", s.tun, tr.Reason))
+		case StateUp:
+			s.alertRecovered(fmt.Sprintf("Tunnel %s is reachable again: %s.", s.tun, tr.Reason))
+		}
+	}
+	switch s.health.State {
+	case StateUp:
+		s.Log(fmt.Sprintf("Tunnel %s is reachable. No action needed.", s.tun))
+		return 0
+	case StateDegraded:
+		if s.health.Breach == "" {
+			s.Log(fmt.Sprintf("Tunnel %s is %s. Waiting for more checks before acting.", s.tun, s.health.State))
+			return 0
+		}
+		s.Log(fmt.Sprintf("Tunnel %s is degraded: %s (%s)", s.tun, s.health.Breach, stats))
+		// The open incident remembers the degraded ticket across runs of `pingo check` and daemon restarts
+		if inc := state.Get(s.Name).Incident; inc == nil || inc.Stage != StageDegraded {
+			s.Log("Opening a lower-priority ticket. The tunnel is passing traffic, so it will not be restarted.")
+			s.openTicket(StageDegraded, s.tun, stats)
+			s.addNote(fmt.Sprintf("Tunnel is degraded: %s. Probe results: %s.", s.health.Breach, stats))
+			s.alert(EventDegraded, fmt.Sprintf("Tunnel %s is degraded: %s. Probe results: %s.", s.tun, s.health.Breach, stats))
+		}
+		return 0
+	case StateRecovering:
+		s.Log(fmt.Sprintf("Tunnel %s is %s. Waiting for more checks before acting.", s.tun, s.health.State))
+		return 0
+	case StateFlapping:
+		if changed {
+			s.Log(fmt.Sprintf("Tunnel %s is flapping. Opening a ticket and pausing remediation.", s.tun))
+			s.openTicket(StageFlapping, s.tun, stats)
+			s.addNote("Tunnel is flapping. Automatic restarts are paused until it settles.")
+			s.alert(EventFlapping, fmt.Sprintf("Tunnel %s is flapping: %s. Automatic restarts are paused until it settles.", s.tun, tr.Reason))
+		}
+		return 0
+	}
+
+	return s.escalate(ctx, stats)
+}
+
+// escalate walks the decision tree once the tunnel is Down
+// If the WAN address is down, it will check the device address (if all three are down, the device is most likely disconnected from the network)
+// Either way a ticket is opened for that stage, since a restart can't help
+// If the tunnel is down, but the WAN address is up, it will attempt to recover and submit a ticket
+// tun holds the results of the failed tunnel probe.
+func (s *Site) escalate(ctx context.Context, tun ProbeStats) int {
+	s.Log(fmt.Sprintf("Tunnel %s is unreachable. Testing %s", s.tun, s.wan))
+
+	wan, err := s.test(ctx, s.wan)
+	if err != nil {
+		return 6
+	}
+	if !wan.Up() {
+		s.Log(fmt.Sprintf("WAN %s is also unreachable. Testing %s", s.wan, s.dev))
+
+		dev, err := s.test(ctx, s.dev)
+		if err != nil {
+			return 6
+		}
+		if !dev.Up() {
+			s.Log(fmt.Sprintf("Device %s is unreachable. Host is most likely disconnected from the network.", s.dev))
+			s.openTicket(StageDevice, s.dev, dev)
+			return 1
+		}
+		s.Log(fmt.Sprintf("Device %s is reachable. Host is connected to network with no WAN connection.", s.dev))
+		s.openTicket(StageWAN, s.wan, wan)
+		return 2
+	}
+
+	s.Log(fmt.Sprintf("WAN %s is reachable. Checking for an open ticket and restarting the tunnels...", s.wan))
+	s.openTicket(StageTunnel, s.tun, tun)
+	inc := state.Get(s.Name).Incident
+	if inc != nil && inc.RestartAttempts >= s.MaxRestarts {
+		s.Log(fmt.Sprintf("Tunnel has already been restarted %d times during this incident. Leaving it for a technician.", inc.RestartAttempts))
+		if inc.RestartAttempts == s.MaxRestarts {
+			s.addNote(fmt.Sprintf("Tunnel is still down after %d restart attempts. Automatic restarts are stopped until it recovers.", inc.RestartAttempts))
+			s.alert(EventRemediationFailed, fmt.Sprintf("Tunnel %s is still down after %d restart attempts. Leaving it for a technician.", s.tun, inc.RestartAttempts))
+			s.updateIncident(func(inc *Incident) { inc.RestartAttempts++ })
+		}
+		return 7
+	}
+	s.updateIncident(func(inc *Incident) { inc.RestartAttempts++ })
+	s.addNote("Tunnel is down. Host is attempting to restart the tunnel.")
+	return s.InitTtyToHost(ctx)
+}
+
+// recordState saves the site's health state, refreshes the open incident and closes it once the tunnel is Up.
+func (s *Site) recordState(now time.Time) {
+	var ended *Incident
+	err := state.Update(s.Name, func(ts *TunnelState) {
+		if ts.LastState != s.health.State.String() {
+			ts.LastState, ts.LastChange = s.health.State.String(), s.health.Since
+		}
+		ts.Downs = slices.Clone(s.health.Downs)
+		if ts.Incident == nil {
+			return
+		}
+		if s.health.State == StateUp {
+			ended, ts.Incident = ts.Incident, nil
+			ended.Recovered = now
+			ts.LastIncident = ended
+			return
+		}
+		ts.Incident.LastSeen = now
+	})
+	if err != nil {
+		s.Log(fmt.Sprintf("Failed to save state: %v", err))
+	}
+	if ended != nil {
+		s.recovered(*ended, now)
+	}
+}
+
+// updateIncident applies fn to the site's open incident, opening one first if there isn't one, and saves it.
+func (s *Site) updateIncident(fn func(inc *Incident)) {
+	err := state.Update(s.Name, func(ts *TunnelState) {
+		if ts.Incident == nil {
+			now := time.Now()
+			ts.Incident = &Incident{FirstSeen: now, LastSeen: now}
+		}
+		if ts.Incident.ID == "" {
+			ts.Incident.ID = fmt.Sprintf("%s-%d", s.Name, ts.Incident.FirstSeen.UnixNano())
+		}
+		fn(ts.Incident)
+	})
+	if err != nil {
+		s.Log(fmt.Sprintf("Failed to save state: %v", err))
+	}
+}
+
+// openTicket queues a ticket for the site's open incident in the outbox, opening the incident first if there isn't one.
+// The outbox keeps the ticket already recorded for the incident if the ticketer still has it open, and creates one otherwise.
+// stage picks the ticket fields, and p and stats are the probe that failed and its results, for the ticket templates.
+// If the incident already has a ticket for another stage, e.g. the tunnel was down and now the whole site is,
+// that ticket is updated to the new stage's summary, priority and type instead of a second one being opened.
+func (s *Site) openTicket(stage string, p Prober, stats ProbeStats) {
+	var inc Incident
+	var prevStage string
+	s.updateIncident(func(i *Incident) {
+		prevStage, i.Stage = i.Stage, stage
+		inc = *i
+	})
+	data := TicketData{
+		Site:            s.Name,
+		Stage:           stage,
+		Tun:             s.tun.String(),
+		Wan:             s.wan.String(),
+		Dev:             s.dev.String(),
+		Probe:           p.String(),
+		Stats:           stats,
+		Breach:          s.health.Breach,
+		Time:            time.Now(),
+		FirstSeen:       inc.FirstSeen,
+		RestartAttempts: inc.RestartAttempts,
+	}
+	req := TicketRequest{Site: s.Name, Stage: stage, Fields: s.tickets[stage]}
+	req.Summary, req.Description = req.Fields.render(data)
+	pending := outbox.createPending(s.Name, inc.ID)
+	if (inc.TicketID != "" || pending) && prevStage != "" && prevStage != stage {
+		s.Log(fmt.Sprintf("Failure moved from the %s stage to the %s stage. Updating the incident's ticket.", prevStage, stage))
+		s.queueFor(inc, OutboxOp{Kind: OpUpdate, Stage: stage, Request: &req})
+		s.queueFor(inc, OutboxOp{Kind: OpNote, Text: fmt.Sprintf("Failure moved from the %s stage to the %s stage. %s: %s.", prevStage, stage, p, stats)})
+		return
+	}
+	if pending {
+		return // The queued create covers this check too
+	}
+	if inc.TicketID != "" {
+		s.Log(fmt.Sprintf("Ticket %s recorded for this incident. Queued a check of it's validity via it's status ID.", inc.TicketID))
+	}
+	s.enqueue(OutboxOp{Kind: OpCreate, Stage: stage, IncidentID: inc.ID, Request: &req})
+}
+
+// addNote queues a note for the ticket of the site's open incident.
+func (s *Site) addNote(note string) {
+	var inc Incident
+	s.updateIncident(func(i *Incident) { inc = *i })
+	s.queueFor(inc, OutboxOp{Kind: OpNote, Text: note})
+}
+
+// queueFor queues an operation on the ticket of inc. An operation queued before the incident's ticket
+// exists waits in the outbox until the ticket is created.
+func (s *Site) queueFor(inc Incident, op OutboxOp) {
+	op.IncidentID = inc.ID
+	if !outbox.createPending(s.Name, inc.ID) {
+		op.TicketID = inc.TicketID
+	}
+	s.enqueue(op)
+}
+
+// enqueue saves op to the outbox on behalf of the site, logging rather than failing if it can't be written.
+func (s *Site) enqueue(op OutboxOp) {
+	op.Site, op.Ticketer = s.Name, s.Ticketer
+	if err := outbox.Enqueue(op); err != nil {
+		s.Log(fmt.Sprintf("Failed to queue ticket %s: %v", op.Kind, err))
+	}
+}
+
+// Run checks the tunnel every interval until ctx is cancelled. A check that is running by then still finishes,
+// unless checkCtx, which it runs under, is cancelled too. A failed check is logged and the site simply waits for the next cycle.
+func (s *Site) Run(ctx, checkCtx context.Context, interval time.Duration) {
+	for ctx.Err() == nil {
+		if code := s.Check(checkCtx); code != 0 {
+			s.Log(fmt.Sprintf("Check finished with code %d. Retrying in %s", code, interval))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// sshTimeout bounds connecting to, and finishing the SSH handshake with, each hop.
+const sshTimeout = 5 * time.Second
+
+// UnmarshalYAML accepts either [user@]host[:port] or a full mapping.
+func (t *SSHTarget) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		type plain SSHTarget
+		return n.Decode((*plain)(t))
+	}
+	hostport := n.Value
+	if user, rest, ok := strings.Cut(hostport, "@"); ok {
+		t.User, hostport = user, rest
+	}
+	t.Host = hostport
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("line %d: bad port in %q", n.Line, n.Value)
+		}
+		t.Host, t.Port = host, p
+	} else if strings.HasPrefix(hostport, "[") && strings.HasSuffix(hostport, "]") {
+		t.Host = hostport[1 : len(hostport)-1] // An IPv6 address without a port
+	}
+	return nil
+}
+
+// withDefaults fills in the settings left unset from deviceTty.
+func (t SSHTarget) withDefaults(d TtyConfig) SSHTarget {
+	if t.Port == 0 {
+		t.Port = 22
+	}
+	if t.User == "" {
+		t.User = d.User
+	}
+	if t.Cred == "" {
+		t.Cred = d.Cred
+	}
+	if t.HostKeys == "" {
+		t.HostKeys = d.HostKeys
+	}
+	if len(t.Auth) == 0 {
+		t.Auth = d.Auth
+	}
+	return t
+}
+
+// jumpDefaults fills in the settings of a jump host left unset from deviceTty. Unlike the device, a jump host
+// doesn't get deviceTty's cred or its password and keyboard-interactive methods, only its key and agent
+// methods, which never reveal a secret to the server. See jumpAuth.
+func (t SSHTarget) jumpDefaults(d TtyConfig) SSHTarget {
+	if t.Port == 0 {
+		t.Port = 22
+	}
+	if t.User == "" {
+		t.User = d.User
+	}
+	if t.HostKeys == "" {
+		t.HostKeys = d.HostKeys
+	}
+	if len(t.Auth) == 0 {
+		t.Auth = jumpAuth(d.Auth, t.Cred)
+	}
+	return t
+}
+
+// jumpAuth returns the login methods of a jump host without auth of its own: the key and agent methods of
+// the device's auth, then, if the jump host has its own cred, the default password methods.
+func jumpAuth(device []SSHAuth, cred string) []SSHAuth {
+	var auth []SSHAuth
+	for _, a := range device {
+		if a.Method == AuthKey || a.Method == AuthAgent {
+			auth = append(auth, a)
+		}
+	}
+	if cred != "" {
+		auth = append(auth, defaultSSHAuth...)
+	}
+	return auth
+}
+
+// validate checks t, naming it prefix in errors.
+func (t SSHTarget) validate(prefix string) error {
+	var errs []error
+	if t.Host == "" {
+		errs = append(errs, fmt.Errorf("%s.host is required", prefix))
+	}
+	if t.Port < 1 || t.Port > 65535 {
+		errs = append(errs, fmt.Errorf("%s.port must be between 1 and 65535", prefix))
+	}
+	if t.User == "" {
+		errs = append(errs, fmt.Errorf("%s.user (or deviceTty.user) is required", prefix))
+	}
+	if len(t.Auth) == 0 {
+		errs = append(errs, fmt.Errorf("%s needs its own cred or auth, jump hosts only get deviceTty's key and agent methods", prefix))
+	}
+	if t.Cred == "" && slices.ContainsFunc(t.Auth, SSHAuth.needsCred) {
+		errs = append(errs, fmt.Errorf("%s.cred (or deviceTty.cred) is required", prefix))
+	}
+	for n, a := range t.Auth {
+		errs = append(errs, a.validate(fmt.Sprintf("%s.auth[%d]", prefix, n)))
+	}
+	if t.HostKeys != HostKeysStrict && t.HostKeys != HostKeysTOFU {
+		errs = append(errs, fmt.Errorf("%s.hostKeys must be strict or tofu, got %q", prefix, t.HostKeys))
+	}
+	for _, fp := range t.Fingerprints {
+		if err := validateFingerprint(fp); err != nil {
+			errs = append(errs, fmt.Errorf("%s.fingerprints: %w", prefix, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// addr returns the host and port to dial.
+func (t SSHTarget) addr() string {
+	return net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+}
+
+// String returns t in the user@host:port form of OpenSSH ProxyJump.
+func (t SSHTarget) String() string {
+	return t.User + "@" + t.addr()
+}
+
+// route describes how t is reached, e.g. "admin@10.0.0.1:22 via jump@203.0.113.5:22".
+func (t SSHTarget) route() string {
+	if len(t.Jump) == 0 {
+		return t.String()
+	}
+	hops := make([]string, len(t.Jump))
+	for i, j := range t.Jump {
+		hops[i] = j.String()
+	}
+	return fmt.Sprintf("%s via %s", t, strings.Join(hops, ", "))
+}
+
+// dialSSH logs into t, going through its jump hosts in order the way OpenSSH ProxyJump does: each hop is
+// reached through a direct-tcpip channel of the one before it and authenticated end to end, so the jump
+// hosts never see the device's credentials. Nor are they sent the device's password, see jumpDefaults. Every hop's host key is checked with its own settings.
+// The returned function closes every connection.
+func dialSSH(logf func(string), t SSHTarget) (*ssh.Client, func(), error) {
+	var clients []*ssh.Client
+	closeAll := func() {
+		for _, c := range slices.Backward(clients) {
+			c.Close()
+		}
+	}
+	device := t
+	device.Jump = nil
+	for _, hop := range append(slices.Clone(t.Jump), device) {
+		c, err := dialHop(logf, hop, clients)
+		if err != nil {
+			closeAll()
+			if len(t.Jump) > 0 {
+				return nil, nil, fmt.Errorf("%s: %w", hop, err)
+			}
+			return nil, nil, err
+		}
+		clients = append(clients, c)
+	}
+	return clients[len(clients)-1], closeAll, nil
+}
+
+// dialHop logs into hop, through the last of via if there is one.
+func dialHop(logf func(string), hop SSHTarget, via []*ssh.Client) (*ssh.Client, error) {
+	addr := hop.addr()
+	hostKeys, algos, err := hostKeyCallback(logf, hop, addr)
+	if err != nil {
+		return nil, err
+	}
+	auth, closeAuth := sshAuthMethods(logf, hop)
+	defer closeAuth()
+	config := &ssh.ClientConfig{
+		User:              hop.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: algos,
+		Timeout:           sshTimeout,
+	}
+	if len(via) == 0 {
+		return ssh.Dial("tcp", addr, config)
+	}
+	conn, err := via[len(via)-1].Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// Channel connections don't support deadlines, so a hop that stalls mid-handshake is cut off by closing it.
+	timer := time.AfterFunc(sshTimeout, func() { conn.Close() })
+	cc, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !timer.Stop() && err != nil {
+		err = fmt.Errorf("%w (timed out after %s)", err, sshTimeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(cc, chans, reqs), nil
+}
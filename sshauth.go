@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSH auth methods.
+const (
+	AuthKey                 = "key"                  // A private key file
+	AuthAgent               = "agent"                // The keys of the ssh-agent at SSH_AUTH_SOCK
+	AuthPassword            = "password"             // Plain password auth
+	AuthKeyboardInteractive = "keyboard-interactive" // Answers the device's prompts
+)
+
+// SSHAuth is one way of logging in to a device. A device's methods are tried in the order they are listed,
+// moving on when the device rejects one or doesn't offer it.
+type SSHAuth struct {
+	Method     string      `yaml:"method"`     // key, agent, password or keyboard-interactive
+	KeyFile    string      `yaml:"keyFile"`    // key: OpenSSH, PKCS#1 or PKCS#8 private key; ~/ is the home directory
+	Passphrase string      `yaml:"passphrase"` // key: passphrase of an encrypted key
+	Password   string      `yaml:"password"`   // password, keyboard-interactive: defaults to the ssh cred
+	Prompts    []SSHPrompt `yaml:"prompts"`    // keyboard-interactive: answers to specific prompts, other prompts get the password
+}
+
+// SSHPrompt answers keyboard-interactive prompts that match a regular expression, ignoring case.
+type SSHPrompt struct {
+	Match  string `yaml:"match"`
+	Answer string `yaml:"answer"`
+}
+
+// defaultSSHAuth is used when neither the tunnel nor deviceTty lists auth methods: the cred as a password,
+// then as the answer to every keyboard-interactive prompt.
+var defaultSSHAuth = []SSHAuth{{Method: AuthPassword}, {Method: AuthKeyboardInteractive}}
+
+// needsCred reports whether a has no secret of its own and falls back to the ssh cred.
+func (a SSHAuth) needsCred() bool {
+	return (a.Method == AuthPassword || a.Method == AuthKeyboardInteractive) && a.Password == ""
+}
+
+// validate checks a, naming it prefix in errors.
+func (a SSHAuth) validate(prefix string) error {
+	var errs []error
+	switch a.Method {
+	case AuthKey:
+		if a.KeyFile == "" {
+			errs = append(errs, fmt.Errorf("%s.keyFile is required for key auth", prefix))
+		}
+	case AuthAgent, AuthPassword:
+	case AuthKeyboardInteractive:
+		for i, p := range a.Prompts {
+			if _, err := regexp.Compile(p.Match); err != nil {
+				errs = append(errs, fmt.Errorf("%s.prompts[%d].match: %w", prefix, i, err))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.method must be key, agent, password or keyboard-interactive, got %q", prefix, a.Method))
+	}
+	return errors.Join(errs...)
+}
+
+// sshAuthMethods turns t.Auth into ssh auth methods. Keys from key files and the agent are offered together,
+// in the listed order, since the device is only asked about public keys once. A key file or agent that
+// can't be used is logged and skipped so the remaining methods still get their turn.
+// The returned function closes the agent connection, if one was opened.
+func sshAuthMethods(logf func(string), t SSHTarget) ([]ssh.AuthMethod, func()) {
+	var methods []ssh.AuthMethod
+	var signers []ssh.Signer
+	var agents []agent.ExtendedAgent
+	var conns []net.Conn
+	keysAt := -1 // Position of the public key method among methods
+	closeAll := func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	for _, a := range t.Auth {
+		password := a.Password
+		if password == "" {
+			password = t.Cred
+		}
+		switch a.Method {
+		case AuthKey:
+			signer, err := loadKeyFile(a.KeyFile, a.Passphrase)
+			if err != nil {
+				logf(fmt.Sprintf("Skipping SSH key %s: %v", a.KeyFile, err))
+				continue
+			}
+			signers = append(signers, signer)
+		case AuthAgent:
+			sock := os.Getenv("SSH_AUTH_SOCK")
+			if sock == "" {
+				logf("Skipping ssh-agent auth: SSH_AUTH_SOCK is not set")
+				continue
+			}
+			conn, err := net.Dial("unix", sock)
+			if err != nil {
+				logf(fmt.Sprintf("Skipping ssh-agent auth: %v", err))
+				continue
+			}
+			conns = append(conns, conn)
+			agents = append(agents, agent.NewClient(conn))
+		case AuthPassword:
+			methods = append(methods, ssh.Password(password))
+			continue
+		case AuthKeyboardInteractive:
+			methods = append(methods, ssh.KeyboardInteractive(answerPrompts(a.Prompts, password)))
+			continue
+		}
+		if keysAt < 0 {
+			keysAt = len(methods)
+			methods = append(methods, nil) // Filled in below once every key source is known
+		}
+	}
+	if keysAt >= 0 {
+		methods[keysAt] = ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			all := append([]ssh.Signer(nil), signers...)
+			for _, ag := range agents {
+				s, err := ag.Signers()
+				if err != nil {
+					logf(fmt.Sprintf("Failed to list ssh-agent keys: %v", err))
+					continue
+				}
+				all = append(all, s...)
+			}
+			return all, nil
+		})
+	}
+	return methods, closeAll
+}
+
+// loadKeyFile reads the private key at path, decrypting it with passphrase if it is encrypted.
+func loadKeyFile(path, passphrase string) (ssh.Signer, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, rest)
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		return nil, errors.New("the key is encrypted and no passphrase is configured")
+	}
+	return signer, err
+}
+
+// answerPrompts answers each keyboard-interactive prompt with the first of prompts that matches it,
+// or with password if none does.
+func answerPrompts(prompts []SSHPrompt, password string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i, q := range questions {
+			answers[i] = password
+			for _, p := range prompts {
+				if re, err := regexp.Compile("(?i)" + p.Match); err == nil && re.MatchString(q) {
+					answers[i] = p.Answer
+					break
+				}
+			}
+		}
+		return answers, nil
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Incident is an outage pingo is tracking for a tunnel, from the first failed check until it is Up again.
+type Incident struct {
+	ID              string    `json:"id"` // Links queued outbox operations to the incident
+	TicketID        TicketID  `json:"ticketId"`
+	Stage           string    `json:"stage,omitempty"` // Failure stage the ticket was last opened or updated for
+	FirstSeen       time.Time `json:"firstSeen"`
+	LastSeen        time.Time `json:"lastSeen"`
+	RestartAttempts int       `json:"restartAttempts"`
+
+	RestartsSucceeded int       `json:"restartsSucceeded"`
+	Recovered         time.Time `json:"recovered,omitzero"`        // Set once the tunnel is Up again
+	HostKeyMismatch   string    `json:"hostKeyMismatch,omitempty"` // Fingerprint of the unexpected key the device last offered, if any
+}
+
+// TunnelState is what pingo remembers about a tunnel between checks and across restarts.
+type TunnelState struct {
+	LastState  string    `json:"lastState"`
+	LastChange time.Time `json:"lastChange"`
+	Incident   *Incident `json:"incident,omitempty"`
+
+	LastIncident *Incident   `json:"lastIncident,omitempty"` // The most recent incident that ended, kept for its recovery time
+	Alerted      string      `json:"alerted,omitempty"`      // Highest severity notified during the current outage
+	Alerts       []string    `json:"alerts,omitempty"`       // Notification events already sent during the current outage
+	Downs        []time.Time `json:"downs,omitempty"`        // When the tunnel recently went Down, for flap detection across runs of `pingo check`
+}
+
+// StateStore keeps per-tunnel state in a JSON file. Every update rewrites the file atomically,
+// so a crash can never leave it half written.
+type StateStore struct {
+	path    string
+	mu      sync.Mutex
+	tunnels map[string]*TunnelState
+}
+
+// state is opened at startup from cfg.StateFile.
+var state *StateStore
+
+// OpenStateStore loads the state file at path. A missing file starts an empty store.
+func OpenStateStore(path string) (*StateStore, error) {
+	st := &StateStore{path: path, tunnels: make(map[string]*TunnelState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &st.tunnels); err != nil {
+		return nil, fmt.Errorf("parsing state %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// Get returns a copy of the named tunnel's state.
+func (st *StateStore) Get(name string) TunnelState {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ts, ok := st.tunnels[name]
+	if !ok {
+		return TunnelState{}
+	}
+	out := *ts
+	if ts.Incident != nil {
+		inc := *ts.Incident
+		out.Incident = &inc
+	}
+	return out
+}
+
+// Update applies fn to the named tunnel's state and saves the store.
+func (st *StateStore) Update(name string, fn func(ts *TunnelState)) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ts, ok := st.tunnels[name]
+	if !ok {
+		ts = &TunnelState{}
+		st.tunnels[name] = ts
+	}
+	fn(ts)
+	return st.save()
+}
+
+// save writes the store to disk.
+func (st *StateStore) save() error {
+	data, err := json.MarshalIndent(st.tunnels, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling state: %w", err)
+	}
+	if err := writeFileAtomic(st.path, data); err != nil {
+		return fmt.Errorf("writing state: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place,
+// so readers never see a half-written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
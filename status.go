@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"pingo/manage"
+)
+
+// boardCheckTimeout bounds the board status lookups made when the daemon starts or reloads, and
+// boardCheckOnceTimeout those of `pingo check`, which makes a single attempt.
+const (
+	boardCheckTimeout     = time.Minute
+	boardCheckOnceTimeout = 10 * time.Second
+)
+
+// ticketClosed reports whether a ticket is closed. Manage's closedFlag decides by default; a status listed
+// in ticket.closedStatuses for the ticket's board also counts, for boards that park finished tickets in
+// statuses Manage doesn't flag as closed, such as a QA review.
+func ticketClosed(t *manage.Ticket) bool {
+	return t.ClosedFlag || cfg.Ticket.closedStatus(t.Board.ID, t.Status.ID, t.Status.Name)
+}
+
+// verifyBoardStatuses fetches the statuses of every board named in the ticket config and reports any
+// configured status ID or name the board doesn't have, so a typo surfaces at startup rather than as
+// duplicate or missing tickets. Boards that can't be fetched within timeout because Manage is unavailable are skipped with a log line.
+// Only tunnels that open their tickets in Manage are checked, and nothing is if there are none.
+func verifyBoardStatuses(client *manage.Client, c *Config, timeout time.Duration) error {
+	if !c.usesManage() {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	boards := map[int][]manage.BoardStatus{}
+	fetch := func(board int) ([]manage.BoardStatus, error) {
+		if ss, ok := boards[board]; ok {
+			return ss, nil
+		}
+		ss, err := client.BoardStatuses(ctx, board)
+		switch {
+		case errors.Is(err, manage.ErrNotFound):
+			return nil, fmt.Errorf("board %d does not exist in Manage", board)
+		case err != nil:
+			AddtoLog(fmt.Sprintf("Could not fetch the statuses of board %d, skipping the check: %v", board, err))
+			ss = nil
+		}
+		boards[board] = ss
+		return ss, nil
+	}
+
+	var errs []error
+	type boardStatus struct {
+		board, status int
+		open          bool
+	}
+	checked := map[boardStatus]bool{}
+	// check reports status if it is not on board, or if open is set and it is a closed status.
+	check := func(prefix string, board, status int, open bool) {
+		if checked[boardStatus{board, status, open}] {
+			return
+		}
+		checked[boardStatus{board, status, open}] = true
+		ss, err := fetch(board)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+			return
+		}
+		if ss == nil || status == 0 {
+			return
+		}
+		i := slices.IndexFunc(ss, func(s manage.BoardStatus) bool { return s.ID == status })
+		switch {
+		case i < 0:
+			errs = append(errs, fmt.Errorf("%s: status %d is not on board %d", prefix, status, board))
+		case open && (ss[i].ClosedStatus || c.Ticket.closedStatus(board, ss[i].ID, ss[i].Name)):
+			errs = append(errs, fmt.Errorf("%s: status %d (%s) on board %d is a closed status", prefix, status, ss[i].Name, board))
+		}
+	}
+	for _, tun := range c.Tunnels {
+		if tun.Ticketer != TicketerConnectWise {
+			continue
+		}
+		for _, st := range ticketStages {
+			f := tun.tickets[st]
+			check(fmt.Sprintf("tunnels[%s].ticket (%s)", tun.Name, st), f.Board, f.Status, true)
+		}
+		if r := tun.Recovery; r.Action == RecoveryResolve {
+			for _, st := range ticketStages {
+				check(fmt.Sprintf("tunnels[%s].recovery.status", tun.Name), tun.tickets[st].Board, r.Status, false)
+			}
+		}
+	}
+	for n, set := range c.Ticket.ClosedStatuses {
+		prefix := fmt.Sprintf("ticket.closedStatuses[%d]", n)
+		ss, err := fetch(set.Board)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+			continue
+		}
+		if ss == nil {
+			continue
+		}
+		for _, id := range set.IDs {
+			if !slices.ContainsFunc(ss, func(s manage.BoardStatus) bool { return s.ID == id }) {
+				errs = append(errs, fmt.Errorf("%s: status %d is not on board %d", prefix, id, set.Board))
+			}
+		}
+		for _, name := range set.Names {
+			if !slices.ContainsFunc(ss, func(s manage.BoardStatus) bool { return sameStatusName(name, s.Name) }) {
+				errs = append(errs, fmt.Errorf("%s: status %q is not on board %d", prefix, name, set.Board))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closedStatus reports whether the status with the given ID or name is listed in t.ClosedStatuses for board.
+func (t TicketConfig) closedStatus(board, id int, name string) bool {
+	for _, set := range t.ClosedStatuses {
+		if set.Board == board && (slices.Contains(set.IDs, id) || slices.ContainsFunc(set.Names, func(n string) bool { return sameStatusName(n, name) })) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameStatusName compares status names the way Manage users type them, ignoring case and surrounding spaces.
+func sameStatusName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
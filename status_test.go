@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"pingo/manage"
+)
+
+func TestVerifyBoardStatuses(t *testing.T) {
+	srv := newStandIn(t)
+	const statuses = "/v4_6_release/apis/3.0/service/boards/1/statuses"
+	once := manage.NewClient(manage.Config{Site: srv.URL, Release: "v4_6_release", MaxRetries: -1})
+	c := &Config{Tunnels: []TunnelConfig{{Name: "hq", Ticketer: TicketerConnectWise, tickets: map[string]TicketFields{}}}}
+	setStatus := func(status int) {
+		for _, st := range ticketStages {
+			c.Tunnels[0].tickets[st] = TicketFields{Board: 1, Status: status}
+		}
+	}
+	setStatus(99)
+
+	srv.handle("GET", statuses, http.StatusOK, []map[string]any{{"id": 1, "name": "New"}, {"id": 2, "name": "Closed", "closedStatus": true}})
+	if err := verifyBoardStatuses(once, c, boardCheckOnceTimeout); err == nil || !strings.Contains(err.Error(), "status 99 is not on board 1") {
+		t.Errorf("status missing from the board returned %v", err)
+	}
+	setStatus(1)
+	if err := verifyBoardStatuses(once, c, boardCheckOnceTimeout); err != nil {
+		t.Errorf("status on the board returned %v", err)
+	}
+
+	srv.handle("GET", statuses, http.StatusServiceUnavailable, nil)
+	before := len(srv.requests)
+	if err := verifyBoardStatuses(once, c, boardCheckOnceTimeout); err != nil {
+		t.Errorf("Manage outage returned %v, want the check skipped", err)
+	}
+	if n := len(srv.requests) - before; n != 1 {
+		t.Errorf("made %d requests during an outage, want a single attempt", n)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Failure stages a ticket can be opened for. Each stage can set its own ticket fields under ticket.stages.
+const (
+	StageTunnel   = "tunnel"   // The tunnel is down but the WAN address answers
+	StageWAN      = "wan"      // The WAN address is down too but the device answers
+	StageDevice   = "device"   // Nothing answers, the device is most likely offline
+	StageDegraded = "degraded" // The tunnel is up but over its loss/latency/jitter thresholds
+	StageFlapping = "flapping" // The tunnel keeps going down and coming back
+)
+
+// ticketStages lists every stage in the order they are reported.
+var ticketStages = []string{StageTunnel, StageWAN, StageDevice, StageDegraded, StageFlapping}
+
+// defaultDescription is used when no description template is configured.
+const defaultDescription = `pingo detected a {{.Stage}} failure on {{.Site}} at {{.Time.Format "2006-01-02 15:04:05"}}.
+{{if .Breach}}Breach: {{.Breach}}
+{{end}}Probe: {{.Probe}}
+Results: {{.Stats}}
+Tunnel: {{.Tun}}  WAN: {{.Wan}}  Device: {{.Dev}}`
+
+// summaryMaxLen is the longest summary Manage accepts.
+const summaryMaxLen = 100
+
+// TicketFields are the fields of a new ticket. They can be set at the top level of ticket, under
+// ticket.stages, under a tunnel's ticket section and under its stages; the most specific value set wins.
+// The IDs are Manage IDs; other ticketers only use Priority.
+// Summary and Description are text/templates executed with TicketData.
+type TicketFields struct {
+	Summary     string `yaml:"summary"`     // A summary without template actions has " - <site name>" appended
+	Description string `yaml:"description"` // Initial description, defaults to a summary of the failure
+	Contact     int    `yaml:"contact"`
+	Board       int    `yaml:"board"`
+	Status      int    `yaml:"status"`
+	Company     int    `yaml:"company"`
+	Type        int    `yaml:"type"`
+	SubType     int    `yaml:"subType"`
+	Item        int    `yaml:"item"`
+	Priority    int    `yaml:"priority"`
+}
+
+// TunnelTicketConfig overrides the top-level ticket fields for one tunnel, optionally per stage.
+type TunnelTicketConfig struct {
+	TicketFields `yaml:",inline"`
+	Stages       map[string]TicketFields `yaml:"stages"`
+}
+
+// TicketData is what summary and description templates can reference.
+type TicketData struct {
+	Site            string
+	Stage           string // tunnel, wan, device, degraded or flapping
+	Tun, Wan, Dev   string // Probe targets
+	Probe           string // The probe that failed
+	Stats           ProbeStats
+	Breach          string // The threshold a degraded tunnel is over
+	Time            time.Time
+	FirstSeen       time.Time // Start of the incident
+	RestartAttempts int
+}
+
+// merge returns f with every field that is set in over replaced.
+func (f TicketFields) merge(over TicketFields) TicketFields {
+	set := func(dst *int, v int) {
+		if v != 0 {
+			*dst = v
+		}
+	}
+	if over.Summary != "" {
+		f.Summary = over.Summary
+	}
+	if over.Description != "" {
+		f.Description = over.Description
+	}
+	set(&f.Contact, over.Contact)
+	set(&f.Board, over.Board)
+	set(&f.Status, over.Status)
+	set(&f.Company, over.Company)
+	set(&f.Type, over.Type)
+	set(&f.SubType, over.SubType)
+	set(&f.Item, over.Item)
+	set(&f.Priority, over.Priority)
+	return f
+}
+
+// validate checks that the templates of f parse and, for tunnels that open tickets in Manage, that f can open one.
+// Errors name f prefix.
+func (f TicketFields) validate(prefix string, manage bool) error {
+	var errs []error
+	if manage && f.Board == 0 {
+		errs = append(errs, fmt.Errorf("%s: board is required", prefix))
+	}
+	if manage && f.Company == 0 {
+		errs = append(errs, fmt.Errorf("%s: company is required", prefix))
+	}
+	for name, text := range map[string]string{"summary": f.Summary, "description": f.Description} {
+		if _, err := template.New(name).Parse(text); err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s: %w", prefix, name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// render executes the summary and description templates with d. A template that fails to execute
+// falls back to its raw text so the ticket is still opened.
+func (f TicketFields) render(d TicketData) (summary, description string) {
+	summary = f.Summary
+	if !strings.Contains(summary, "{{") {
+		summary += " - {{.Site}}"
+	}
+	exec := func(name, text string) string {
+		var b bytes.Buffer
+		t, err := template.New(name).Parse(text)
+		if err == nil {
+			err = t.Execute(&b, d)
+		}
+		if err != nil {
+			siteLog(d.Site, fmt.Sprintf("Failed to render the ticket %s template: %v", name, err))
+			return text
+		}
+		return b.String()
+	}
+	summary = strings.TrimSpace(exec("summary", summary))
+	if r := []rune(summary); len(r) > summaryMaxLen {
+		summary = string(r[:summaryMaxLen])
+	}
+	return summary, exec("description", f.Description)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"pingo/manage"
+)
+
+// Ticketer types. connectwise is always available and uses the manage section.
+const (
+	TicketerConnectWise = "connectwise"
+	TicketerJira        = "jira"
+	TicketerServiceNow  = "servicenow"
+	TicketerWebhook     = "webhook"
+)
+
+// ErrTicketNotFound is returned by Ticketer.IsOpen when the backend has no ticket with that ID.
+var ErrTicketNotFound = errors.New("ticket not found")
+
+// TicketID identifies a ticket in its backend: a Manage ticket number, a Jira issue key, a ServiceNow sys_id
+// or whatever a webhook endpoint answered with.
+type TicketID string
+
+// UnmarshalJSON also accepts the bare numbers older state files recorded for Manage tickets.
+func (id *TicketID) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*id = ""
+		if n != 0 {
+			*id = TicketID(strconv.Itoa(n))
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("ticket ID must be a string or number: %w", err)
+	}
+	*id = TicketID(s)
+	return nil
+}
+
+// TicketRequest is a ticket to open, or the fields to move an existing ticket to, rendered for one failure stage.
+type TicketRequest struct {
+	Site        string       `json:"site"`
+	Stage       string       `json:"stage"`
+	Summary     string       `json:"summary"`
+	Description string       `json:"description"`
+	Fields      TicketFields `json:"fields"` // The stage's resolved fields; each backend uses the ones it understands
+}
+
+// Ticketer is a ticketing system pingo opens and updates incident tickets in.
+type Ticketer interface {
+	Create(ctx context.Context, r TicketRequest) (TicketID, error)
+	// IsOpen reports whether the ticket is still open and its status as the backend names it, for the log,
+	// or returns ErrTicketNotFound.
+	IsOpen(ctx context.Context, id TicketID) (open bool, status string, err error)
+	// AddNote adds a note. resolution marks notes that describe how the outage ended.
+	AddNote(ctx context.Context, id TicketID, text string, resolution bool) error
+	// Resolve closes the ticket. status is the tunnel's recovery.status; backends with their own resolve setting ignore it.
+	Resolve(ctx context.Context, id TicketID, status int) error
+}
+
+// ticketUpdater is implemented by Ticketers that can move an existing ticket to another stage's fields.
+type ticketUpdater interface {
+	Update(ctx context.Context, id TicketID, r TicketRequest) error
+}
+
+// ticketFinder is implemented by Ticketers that can search for an open ticket pingo opened for a tunnel,
+// so a lost state file doesn't lead to a duplicate.
+// It returns an empty TicketID when there is nothing to adopt.
+type ticketFinder interface {
+	FindOpen(ctx context.Context, r TicketRequest) (id TicketID, summary string, err error)
+}
+
+// ticketers holds every configured backend by name, rebuilt from cfg at startup and on reload.
+var ticketers map[string]Ticketer
+
+// TicketerConfig defines a ticketing backend that tunnels pick by name with their ticketer setting.
+type TicketerConfig struct {
+	Type       string           `yaml:"type"` // jira, servicenow or webhook
+	Jira       JiraConfig       `yaml:"jira"`
+	ServiceNow ServiceNowConfig `yaml:"servicenow"`
+	Webhook    WebhookConfig    `yaml:"webhook"`
+}
+
+// validate checks t, naming it prefix in errors.
+func (t TicketerConfig) validate(prefix string) error {
+	switch t.Type {
+	case TicketerJira:
+		return t.Jira.validate(prefix + ".jira")
+	case TicketerServiceNow:
+		return t.ServiceNow.validate(prefix + ".servicenow")
+	case TicketerWebhook:
+		return t.Webhook.validate(prefix + ".webhook")
+	}
+	return fmt.Errorf("%s.type must be jira, servicenow or webhook, got %q", prefix, t.Type)
+}
+
+// newTicketers builds every backend in c. The connectwise backend wraps client.
+func newTicketers(c *Config, client *manage.Client) map[string]Ticketer {
+	ts := map[string]Ticketer{TicketerConnectWise: &connectWiseTicketer{client: client}}
+	for name, t := range c.Ticketers {
+		switch t.Type {
+		case TicketerJira:
+			ts[name] = newJiraTicketer(t.Jira)
+		case TicketerServiceNow:
+			ts[name] = newServiceNowTicketer(t.ServiceNow)
+		case TicketerWebhook:
+			ts[name] = newWebhookTicketer(t.Webhook)
+		}
+	}
+	return ts
+}
+
+// isTemporary reports whether a failed ticket operation is worth retrying later.
+func isTemporary(err error) bool {
+	if manage.IsTemporary(err) {
+		return true
+	}
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// summaryMatches reports whether a ticket summary is the one pingo renders, or that followed by a space and
+// anything a technician added. The space keeps tunnel hq from adopting tickets for hq2 or hq-dc.
+func summaryMatches(summary, rendered string) bool {
+	rest, ok := strings.CutPrefix(summary, rendered)
+	return ok && rendered != "" && (rest == "" || rest[0] == ' ')
+}
+
+// requireFields reports every empty value in a list of name, value pairs, naming them prefix.name.
+func requireFields(prefix string, pairs ...string) error {
+	var errs []error
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			errs = append(errs, fmt.Errorf("%s.%s is required", prefix, pairs[i]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkStages reports the keys of a per-stage setting that aren't ticket stages.
+func checkStages[V any](prefix string, m map[string]V) error {
+	var errs []error
+	for _, st := range slices.Sorted(maps.Keys(m)) {
+		if !slices.Contains(ticketStages, st) {
+			errs = append(errs, fmt.Errorf("%s: unknown stage %q, expected one of %s", prefix, st, strings.Join(ticketStages, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"pingo/manage"
+)
+
+// connectWiseTicketer opens tickets in ConnectWise Manage from the manage and ticket sections.
+type connectWiseTicketer struct {
+	client *manage.Client
+}
+
+// manageID converts a TicketID back to a Manage ticket number.
+func manageID(id TicketID) (int, error) {
+	n, err := strconv.Atoi(string(id))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a Manage ticket ID", id)
+	}
+	return n, nil
+}
+
+func (c *connectWiseTicketer) Create(ctx context.Context, r TicketRequest) (TicketID, error) {
+	t, err := c.client.CreateTicket(ctx, PostTicketPayload(r))
+	if err != nil {
+		return "", err
+	}
+	if t.ID == 0 {
+		return "", fmt.Errorf("manage returned a ticket without an ID")
+	}
+	return TicketID(strconv.Itoa(t.ID)), nil
+}
+
+// IsOpen checks the status of a ticket in ConnectWise Manage and returns true if the ticket is still valid (not closed).
+func (c *connectWiseTicketer) IsOpen(ctx context.Context, id TicketID) (bool, string, error) {
+	ticketID, err := manageID(id)
+	if err != nil {
+		return false, "", err
+	}
+	ticketData, err := c.client.GetTicket(ctx, ticketID)
+	if errors.Is(err, manage.ErrNotFound) {
+		return false, "", fmt.Errorf("%w: %w", ErrTicketNotFound, err)
+	}
+	if err != nil {
+		return false, "", err
+	}
+	if ticketData.ID == 0 {
+		return false, "", fmt.Errorf("manage returned an empty ticket for %d", ticketID)
+	}
+	status := fmt.Sprintf("%s (ID: %d)", ticketData.Status.Name, ticketData.Status.ID)
+	return !ticketClosed(ticketData), status, nil // If the ticket is valid, we won't create a new one. If it's been closed (which returns false), we will create a new one.
+}
+
+// AddNote adds a note to a ticket in ConnectWise Manage using the note flags from the config.
+// resolution also sets the resolution flag.
+func (c *connectWiseTicketer) AddNote(ctx context.Context, id TicketID, note string, resolution bool) error {
+	ticketID, err := manageID(id)
+	if err != nil {
+		return err
+	}
+	flags := cfg.Ticket.Notes
+	return c.client.AddNote(ctx, ticketID, manage.TicketNote{
+		Text:                  note,
+		DetailDescriptionFlag: flags.Detail,
+		InternalAnalysisFlag:  flags.Internal,
+		ResolutionFlag:        flags.Resolution || resolution,
+	})
+}
+
+// Update moves a ticket to the summary, type and priority of r.
+func (c *connectWiseTicketer) Update(ctx context.Context, id TicketID, r TicketRequest) error {
+	ticketID, err := manageID(id)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.UpdateTicket(ctx, ticketID, ticketPatch(r))
+	return err
+}
+
+// Resolve moves a ticket to status.
+func (c *connectWiseTicketer) Resolve(ctx context.Context, id TicketID, status int) error {
+	ticketID, err := manageID(id)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.UpdateTicket(ctx, ticketID, []manage.PatchOp{{Op: "replace", Path: "status/id", Value: status}})
+	return err
+}
+
+// FindOpen searches Manage for an open ticket for the tunnel in its company, matching on the ticket.dedupe
+// custom field and, with matchSummary, on a summary that starts with the one pingo renders for the ticket.
+func (c *connectWiseTicketer) FindOpen(ctx context.Context, r TicketRequest) (TicketID, string, error) {
+	d := cfg.Ticket.Dedupe
+	if !d.Enabled {
+		return "", "", nil
+	}
+	open := fmt.Sprintf("company/id=%d and closedFlag=false", r.Fields.Company)
+	var queries []manage.TicketQuery
+	if d.CustomField.ID != 0 {
+		queries = append(queries, manage.TicketQuery{
+			Conditions:            open,
+			CustomFieldConditions: fmt.Sprintf("caption=%s and value=%s", manage.Quote(d.CustomField.Caption), manage.Quote(r.Site)),
+		})
+	}
+	if d.MatchSummary && r.Summary != "" {
+		queries = append(queries, manage.TicketQuery{
+			Conditions: fmt.Sprintf("%s and summary like %s", open, manage.Quote(r.Summary+"*")),
+		})
+	}
+	for _, q := range queries {
+		q.OrderBy, q.PageSize = "dateEntered desc", 25
+		tickets, err := c.client.SearchTickets(ctx, q)
+		if err != nil {
+			return "", "", err
+		}
+		for i := range tickets {
+			if q.CustomFieldConditions == "" && !summaryMatches(tickets[i].Summary, r.Summary) {
+				continue
+			}
+			if !ticketClosed(&tickets[i]) {
+				return TicketID(strconv.Itoa(tickets[i].ID)), tickets[i].Summary, nil
+			}
+		}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"pingo/jira"
+)
+
+// JiraConfig holds a Jira Cloud site and the project pingo opens issues in.
+// Manage's priority IDs in the ticket section don't apply, each stage's Jira priority is set by name here.
+type JiraConfig struct {
+	URL               string            `yaml:"url"`   // e.g. https://acme.atlassian.net
+	Email             string            `yaml:"email"` // Account the API token belongs to
+	Token             string            `yaml:"token"`
+	Project           string            `yaml:"project"`           // Project key, e.g. OPS
+	IssueType         string            `yaml:"issueType"`         // Defaults to Task
+	ResolveTransition string            `yaml:"resolveTransition"` // Transition or target status name used by recovery.action resolve, defaults to Done
+	Priorities        map[string]string `yaml:"priorities"`        // Priority name by ticket stage, e.g. tunnel: Highest. Unlisted stages get the project default
+	Timeout           time.Duration     `yaml:"timeout"`
+}
+
+func (c JiraConfig) validate(prefix string) error {
+	return errors.Join(
+		requireFields(prefix, "url", c.URL, "email", c.Email, "token", c.Token, "project", c.Project),
+		checkStages(prefix+".priorities", c.Priorities),
+	)
+}
+
+// jiraTicketer opens Jira issues. Every issue is labelled pingo-<tunnel> so FindOpen can find it again.
+type jiraTicketer struct {
+	client *jira.Client
+	cfg    JiraConfig
+}
+
+func newJiraTicketer(c JiraConfig) *jiraTicketer {
+	c.IssueType = cmp.Or(c.IssueType, "Task")
+	c.ResolveTransition = cmp.Or(c.ResolveTransition, "Done")
+	return &jiraTicketer{
+		client: jira.NewClient(jira.Config{URL: c.URL, Email: c.Email, Token: c.Token, Timeout: c.Timeout}),
+		cfg:    c,
+	}
+}
+
+// jiraLabelChars matches everything Jira does not allow in a label.
+var jiraLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// jiraLabel is the label that marks an issue as pingo's ticket for the tunnel.
+func jiraLabel(site string) string {
+	return "pingo-" + jiraLabelChars.ReplaceAllString(site, "_")
+}
+
+// fields converts r to Jira issue fields. Project, type and labels are only set on create.
+func (j *jiraTicketer) fields(r TicketRequest) jira.Fields {
+	f := jira.Fields{Summary: r.Summary, Description: r.Description}
+	if p := j.cfg.Priorities[r.Stage]; p != "" {
+		f.Priority = &jira.Ref{Name: p}
+	}
+	return f
+}
+
+func (j *jiraTicketer) Create(ctx context.Context, r TicketRequest) (TicketID, error) {
+	f := j.fields(r)
+	f.Project = &jira.Ref{Key: j.cfg.Project}
+	f.IssueType = &jira.Ref{Name: j.cfg.IssueType}
+	f.Labels = []string{"pingo", jiraLabel(r.Site)}
+	key, err := j.client.CreateIssue(ctx, f)
+	if err != nil {
+		return "", err
+	}
+	return TicketID(key), nil
+}
+
+func (j *jiraTicketer) IsOpen(ctx context.Context, id TicketID) (bool, string, error) {
+	issue, err := j.client.GetIssue(ctx, string(id))
+	if errors.Is(err, jira.ErrNotFound) {
+		return false, "", fmt.Errorf("%w: %w", ErrTicketNotFound, err)
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return !issue.Done(), issue.Fields.Status.Name, nil
+}
+
+func (j *jiraTicketer) AddNote(ctx context.Context, id TicketID, text string, resolution bool) error {
+	return j.client.AddComment(ctx, string(id), text)
+}
+
+// Update moves an issue to the summary, description and priority of r.
+func (j *jiraTicketer) Update(ctx context.Context, id TicketID, r TicketRequest) error {
+	return j.client.UpdateIssue(ctx, string(id), j.fields(r))
+}
+
+// Resolve runs the configured transition, matched by transition name or target status name.
+func (j *jiraTicketer) Resolve(ctx context.Context, id TicketID, status int) error {
+	ts, err := j.client.Transitions(ctx, string(id))
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if strings.EqualFold(t.Name, j.cfg.ResolveTransition) || strings.EqualFold(t.To.Name, j.cfg.ResolveTransition) {
+			return j.client.DoTransition(ctx, string(id), t.ID)
+		}
+	}
+	return fmt.Errorf("jira: issue %s has no %q transition", id, j.cfg.ResolveTransition)
+}
+
+// FindOpen searches the project for an unresolved issue carrying the tunnel's label.
+func (j *jiraTicketer) FindOpen(ctx context.Context, r TicketRequest) (TicketID, string, error) {
+	if !cfg.Ticket.Dedupe.Enabled {
+		return "", "", nil
+	}
+	jql := fmt.Sprintf(`project = %q AND labels = %q AND statusCategory != Done ORDER BY created DESC`, j.cfg.Project, jiraLabel(r.Site))
+	issues, err := j.client.Search(ctx, jql, 1)
+	if err != nil || len(issues) == 0 {
+		return "", "", err
+	}
+	return TicketID(issues[0].Key), issues[0].Fields.Summary, nil
+}
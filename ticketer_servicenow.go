@@ -0,0 +1,143 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"pingo/servicenow"
+)
+
+// ServiceNowConfig holds a ServiceNow instance and the table pingo opens records in.
+// Manage's priority IDs in the ticket section don't apply, each stage's urgency is set here.
+type ServiceNowConfig struct {
+	URL             string         `yaml:"url"` // e.g. https://acme.service-now.com
+	User            string         `yaml:"user"`
+	Password        string         `yaml:"password"`
+	Table           string         `yaml:"table"`           // Defaults to incident
+	AssignmentGroup string         `yaml:"assignmentGroup"` // sys_id or name
+	Caller          string         `yaml:"caller"`          // sys_id or user name
+	Category        string         `yaml:"category"`
+	ResolveState    string         `yaml:"resolveState"` // State set by recovery.action resolve, defaults to 6 (Resolved)
+	CloseCode       string         `yaml:"closeCode"`    // Defaults to "Solved (Permanently)"
+	Urgency         map[string]int `yaml:"urgency"`      // Urgency by ticket stage, 1 (high) to 3 (low). Unlisted stages get the table default
+	Timeout         time.Duration  `yaml:"timeout"`
+}
+
+func (c ServiceNowConfig) validate(prefix string) error {
+	errs := []error{
+		requireFields(prefix, "url", c.URL, "user", c.User, "password", c.Password),
+		checkStages(prefix+".urgency", c.Urgency),
+	}
+	for _, st := range ticketStages {
+		if u, ok := c.Urgency[st]; ok && (u < 1 || u > 3) {
+			errs = append(errs, fmt.Errorf("%s.urgency.%s must be between 1 and 3, got %d", prefix, st, u))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serviceNowTicketer opens records through the Table API. Every record gets pingo-<tunnel> as its
+// correlation ID so FindOpen can find it again.
+type serviceNowTicketer struct {
+	client *servicenow.Client
+	cfg    ServiceNowConfig
+}
+
+func newServiceNowTicketer(c ServiceNowConfig) *serviceNowTicketer {
+	c.Table = cmp.Or(c.Table, "incident")
+	c.ResolveState = cmp.Or(c.ResolveState, "6")
+	c.CloseCode = cmp.Or(c.CloseCode, "Solved (Permanently)")
+	return &serviceNowTicketer{
+		client: servicenow.NewClient(servicenow.Config{URL: c.URL, User: c.User, Password: c.Password, Timeout: c.Timeout}),
+		cfg:    c,
+	}
+}
+
+// fields converts r to record fields. Assignment, caller and correlation are only set on create.
+func (s *serviceNowTicketer) fields(r TicketRequest) map[string]any {
+	f := map[string]any{"short_description": r.Summary, "description": r.Description}
+	if u := s.cfg.Urgency[r.Stage]; u != 0 {
+		f["urgency"] = u
+	}
+	return f
+}
+
+func (s *serviceNowTicketer) Create(ctx context.Context, r TicketRequest) (TicketID, error) {
+	f := s.fields(r)
+	f["correlation_id"] = "pingo-" + r.Site
+	for k, v := range map[string]string{"assignment_group": s.cfg.AssignmentGroup, "caller_id": s.cfg.Caller, "category": s.cfg.Category} {
+		if v != "" {
+			f[k] = v
+		}
+	}
+	rec, err := s.client.Create(ctx, s.cfg.Table, f)
+	if err != nil {
+		return "", err
+	}
+	if rec.SysID() == "" {
+		return "", fmt.Errorf("servicenow returned a record without a sys_id")
+	}
+	siteLog(r.Site, fmt.Sprintf("ServiceNow record %s opened as %s", rec.Field("number"), rec.SysID()))
+	return TicketID(rec.SysID()), nil
+}
+
+func (s *serviceNowTicketer) IsOpen(ctx context.Context, id TicketID) (bool, string, error) {
+	rec, err := s.client.Get(ctx, s.cfg.Table, string(id))
+	if errors.Is(err, servicenow.ErrNotFound) {
+		return false, "", fmt.Errorf("%w: %w", ErrTicketNotFound, err)
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return rec.Field("active") == "true", fmt.Sprintf("state %s (%s)", rec.Field("state"), rec.Field("number")), nil
+}
+
+// AddNote adds a work note, or a customer-visible comment if ticket.notes.internal is off.
+func (s *serviceNowTicketer) AddNote(ctx context.Context, id TicketID, text string, resolution bool) error {
+	field := "work_notes"
+	if !cfg.Ticket.Notes.Internal {
+		field = "comments"
+	}
+	return s.client.Update(ctx, s.cfg.Table, string(id), map[string]any{field: text})
+}
+
+// Update moves a record to the short description, description and urgency of r.
+func (s *serviceNowTicketer) Update(ctx context.Context, id TicketID, r TicketRequest) error {
+	return s.client.Update(ctx, s.cfg.Table, string(id), s.fields(r))
+}
+
+// Resolve sets the configured resolve state and close code.
+func (s *serviceNowTicketer) Resolve(ctx context.Context, id TicketID, status int) error {
+	return s.client.Update(ctx, s.cfg.Table, string(id), map[string]any{
+		"state":       s.cfg.ResolveState,
+		"close_code":  s.cfg.CloseCode,
+		"close_notes": "Resolved by pingo after the tunnel recovered.",
+	})
+}
+
+// FindOpen searches for an active record with the tunnel's correlation ID or, with ticket.dedupe.matchSummary,
+// whose short description starts with the one pingo renders for it.
+func (s *serviceNowTicketer) FindOpen(ctx context.Context, r TicketRequest) (TicketID, string, error) {
+	d := cfg.Ticket.Dedupe
+	if !d.Enabled {
+		return "", "", nil
+	}
+	q := "active=true^correlation_id=pingo-" + r.Site
+	if d.MatchSummary && r.Summary != "" && !strings.Contains(r.Summary, "^") { // ^ would end the condition early
+		q += "^NQactive=true^short_descriptionSTARTSWITH" + r.Summary
+	}
+	recs, err := s.client.Query(ctx, s.cfg.Table, q+"^ORDERBYDESCsys_created_on", 25)
+	if err != nil {
+		return "", "", err
+	}
+	for _, rec := range recs {
+		if rec.Field("correlation_id") == "pingo-"+r.Site || summaryMatches(rec.Field("short_description"), r.Summary) {
+			return TicketID(rec.SysID()), rec.Field("short_description"), nil
+		}
+	}
+	return "", "", nil
+}
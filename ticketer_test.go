@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"pingo/manage"
+)
+
+// TestMain runs the tests in a scratch directory, since pingo logs to pingo.log in the working directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "pingo-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// recorded is a request received by a stand-in server.
+type recorded struct {
+	Method string
+	Path   string
+	Query  string
+	Body   map[string]any
+	Header http.Header
+}
+
+// standIn is an httptest server that records every request and answers with the handler registered
+// for "METHOD /path", or 404.
+type standIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []recorded
+	routes   map[string]func(w http.ResponseWriter, r recorded)
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{routes: map[string]func(http.ResponseWriter, recorded){}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := recorded{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header}
+		if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+			if err := json.Unmarshal(data, &rec.Body); err != nil {
+				t.Errorf("%s %s: body is not a JSON object: %s", r.Method, r.URL.Path, data)
+			}
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, rec)
+		h, ok := s.routes[r.Method+" "+r.URL.Path]
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h(w, rec)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// handle answers method and path with a JSON body and status.
+func (s *standIn) handle(method, path string, status int, body any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.routes[method+" "+path] = func(w http.ResponseWriter, _ recorded) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// last returns the last request received for method and path.
+func (s *standIn) last(t *testing.T, method, path string) recorded {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.requests) - 1; i >= 0; i-- {
+		if r := s.requests[i]; r.Method == method && r.Path == path {
+			return r
+		}
+	}
+	t.Fatalf("no %s %s request", method, path)
+	return recorded{}
+}
+
+// withConfig sets the global config for the duration of the test.
+func withConfig(t *testing.T, c *Config) {
+	prev := cfg
+	cfg = c
+	t.Cleanup(func() { cfg = prev })
+}
+
+func TestConnectWiseTicketer(t *testing.T) {
+	srv := newStandIn(t)
+	withConfig(t, &Config{Ticket: TicketConfig{Dedupe: DedupeConfig{Enabled: true, CustomField: CustomFieldConfig{ID: 9, Caption: "Tunnel"}}}})
+	cw := &connectWiseTicketer{client: manage.NewClient(manage.Config{Site: srv.URL, Release: "v4_6_release", ClientID: "cid", MaxRetries: -1})}
+	const base = "/v4_6_release/apis/3.0"
+	ctx := context.Background()
+
+	srv.handle("POST", base+"/service/tickets", http.StatusCreated, map[string]any{"id": 1234})
+	id, err := cw.Create(ctx, TicketRequest{Site: "hq", Summary: "Tunnel down - hq", Fields: TicketFields{Board: 1, Company: 2, Priority: 6}})
+	if err != nil || id != "1234" {
+		t.Fatalf("Create = %q, %v, want 1234", id, err)
+	}
+	req := srv.last(t, "POST", base+"/service/tickets")
+	if req.Header.Get("clientId") != "cid" || !strings.HasPrefix(req.Header.Get("Authorization"), "Basic ") {
+		t.Errorf("Create sent clientId %q and Authorization %q", req.Header.Get("clientId"), req.Header.Get("Authorization"))
+	}
+	for _, ref := range []string{"contact", "status", "type", "subType", "item"} {
+		if _, ok := req.Body[ref]; ok {
+			t.Errorf("Create sent the unset %s ref", ref)
+		}
+	}
+	if req.Body["priority"].(map[string]any)["id"] != 6.0 {
+		t.Errorf("Create sent priority %v, want 6", req.Body["priority"])
+	}
+	if cf := req.Body["customFields"].([]any)[0].(map[string]any); cf["id"] != 9.0 || cf["value"] != "hq" {
+		t.Errorf("Create sent custom field %v, want the tunnel name in field 9", cf)
+	}
+
+	srv.handle("GET", base+"/service/tickets/1234", http.StatusOK, map[string]any{"id": 1234, "closedFlag": true})
+	if open, status, err := cw.IsOpen(ctx, "1234"); err != nil || open || status == "" {
+		t.Errorf("IsOpen of a closed ticket = %v, %q, %v, want false and its status", open, status, err)
+	}
+	if _, _, err := cw.IsOpen(ctx, "99"); !errors.Is(err, ErrTicketNotFound) {
+		t.Errorf("IsOpen of a missing ticket returned %v, want ErrTicketNotFound", err)
+	}
+
+	srv.handle("GET", base+"/service/tickets", http.StatusOK, []map[string]any{{"id": 77, "summary": "Tunnel down - hq"}})
+	found, _, err := cw.FindOpen(ctx, TicketRequest{Site: "hq", Summary: "Tunnel down - hq", Fields: TicketFields{Company: 2}})
+	if err != nil || found != "77" {
+		t.Fatalf("FindOpen = %q, %v, want 77", found, err)
+	}
+	if q := srv.last(t, "GET", base+"/service/tickets").Query; !strings.Contains(q, "customFieldConditions=") || strings.Contains(q, "summary") {
+		t.Errorf("FindOpen searched with %q, want the custom field only", q)
+	}
+
+	// With the default dedupe settings a ticket a technician opened for the tunnel is adopted, one for another tunnel is not
+	withConfig(t, &Config{Ticket: TicketConfig{Dedupe: DedupeConfig{Enabled: true, MatchSummary: true}}})
+	srv.handle("GET", base+"/service/tickets", http.StatusOK, []map[string]any{
+		{"id": 80, "summary": "SCRIPT TICKET - VPN Tunnel Down - hq2"},
+		{"id": 78, "summary": "SCRIPT TICKET - VPN Tunnel Down - hq (ISP called, fibre cut)"},
+	})
+	found, summary, err := cw.FindOpen(ctx, TicketRequest{Site: "hq", Summary: "SCRIPT TICKET - VPN Tunnel Down - hq", Fields: TicketFields{Company: 2}})
+	if err != nil || found != "78" || !strings.Contains(summary, "ISP called") {
+		t.Fatalf("FindOpen with the default settings = %q, %q, %v, want the hand-opened 78", found, summary, err)
+	}
+	if q := srv.last(t, "GET", base+"/service/tickets").Query; !strings.Contains(q, "summary+like+%22SCRIPT+TICKET+-+VPN+Tunnel+Down+-+hq%2A%22") {
+		t.Errorf("FindOpen searched with %q, want a summary prefix", q)
+	}
+	withConfig(t, &Config{Ticket: TicketConfig{Dedupe: DedupeConfig{Enabled: true, CustomField: CustomFieldConfig{ID: 9, Caption: "Tunnel"}}}})
+
+	srv.handle("POST", base+"/service/tickets/1234/notes", http.StatusCreated, map[string]any{"id": 1})
+	if err := cw.AddNote(ctx, "1234", "recovered", true); err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+	if b := srv.last(t, "POST", base+"/service/tickets/1234/notes").Body; b["text"] != "recovered" || b["resolutionFlag"] != true {
+		t.Errorf("AddNote sent %v", b)
+	}
+
+	srv.handle("POST", base+"/service/tickets", http.StatusBadRequest, map[string]any{
+		"code": "InvalidObject", "message": "ticket object is invalid",
+		"errors": []map[string]any{{"code": "InvalidField", "message": "The board is inactive.", "resource": "ticket", "field": "board"}},
+	})
+	_, err = cw.Create(ctx, TicketRequest{Site: "hq", Fields: TicketFields{Board: 1, Company: 2}})
+	if msg := fmt.Sprint(err); !strings.Contains(msg, "ticket object is invalid") || !strings.Contains(msg, "[board InvalidField: The board is inactive.]") || isTemporary(err) {
+		t.Errorf("Create rejected by Manage returned %q, want its message and field errors as a permanent error", msg)
+	}
+
+	srv.handle("POST", base+"/service/tickets", http.StatusServiceUnavailable, map[string]any{"code": "Unavailable"})
+	if _, err := cw.Create(ctx, TicketRequest{Site: "hq", Fields: TicketFields{Board: 1, Company: 2}}); !isTemporary(err) {
+		t.Errorf("Create during a Manage outage returned %v, want a temporary error", err)
+	}
+}
+
+func TestJiraTicketer(t *testing.T) {
+	srv := newStandIn(t)
+	withConfig(t, &Config{Ticket: TicketConfig{Dedupe: DedupeConfig{Enabled: true}}})
+	j := newJiraTicketer(JiraConfig{URL: srv.URL, Email: "pingo@acme.example", Token: "tok", Project: "OPS",
+		Priorities: map[string]string{StageTunnel: "Highest"}})
+	ctx := context.Background()
+
+	srv.handle("POST", "/rest/api/2/issue", http.StatusCreated, map[string]any{"key": "OPS-7"})
+	id, err := j.Create(ctx, TicketRequest{Site: "hq dc", Stage: StageTunnel, Summary: "Tunnel down", Fields: TicketFields{Priority: 6}})
+	if err != nil || id != "OPS-7" {
+		t.Fatalf("Create = %q, %v, want OPS-7", id, err)
+	}
+	req := srv.last(t, "POST", "/rest/api/2/issue")
+	if user, pass, ok := (&http.Request{Header: req.Header}).BasicAuth(); !ok || user != "pingo@acme.example" || pass != "tok" {
+		t.Errorf("Create authenticated as %q:%q", user, pass)
+	}
+	f := req.Body["fields"].(map[string]any)
+	if p := f["priority"].(map[string]any); p["name"] != "Highest" || p["id"] != nil {
+		t.Errorf("Create sent priority %v, want the tunnel stage's Jira priority by name", p)
+	}
+	if labels := f["labels"].([]any); len(labels) != 2 || labels[1] != "pingo-hq_dc" {
+		t.Errorf("Create sent labels %v", labels)
+	}
+
+	srv.handle("PUT", "/rest/api/2/issue/OPS-7", http.StatusNoContent, nil)
+	if err := j.Update(ctx, "OPS-7", TicketRequest{Stage: StageWAN, Summary: "WAN down", Fields: TicketFields{Priority: 6}}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if f := srv.last(t, "PUT", "/rest/api/2/issue/OPS-7").Body["fields"].(map[string]any); f["priority"] != nil {
+		t.Errorf("Update sent priority %v for a stage without one", f["priority"])
+	}
+
+	srv.handle("GET", "/rest/api/2/issue/OPS-7", http.StatusOK, map[string]any{
+		"key": "OPS-7", "fields": map[string]any{"status": map[string]any{"name": "Done", "statusCategory": map[string]any{"key": "done"}}},
+	})
+	if open, status, err := j.IsOpen(ctx, "OPS-7"); err != nil || open || status != "Done" {
+		t.Errorf("IsOpen of a done issue = %v, %q, %v, want false and Done", open, status, err)
+	}
+	if _, _, err := j.IsOpen(ctx, "OPS-8"); !errors.Is(err, ErrTicketNotFound) {
+		t.Errorf("IsOpen of a missing issue returned %v, want ErrTicketNotFound", err)
+	}
+
+	srv.handle("GET", "/rest/api/2/search/jql", http.StatusOK, map[string]any{"issues": []map[string]any{{"key": "OPS-3"}}})
+	found, _, err := j.FindOpen(ctx, TicketRequest{Site: "hq dc"})
+	if err != nil || found != "OPS-3" {
+		t.Fatalf("FindOpen = %q, %v, want OPS-3", found, err)
+	}
+	if q := srv.last(t, "GET", "/rest/api/2/search/jql").Query; !strings.Contains(q, "pingo-hq_dc") {
+		t.Errorf("FindOpen searched with %q, want the tunnel's label", q)
+	}
+
+	srv.handle("GET", "/rest/api/2/issue/OPS-7/transitions", http.StatusOK, map[string]any{
+		"transitions": []map[string]any{{"id": "11", "name": "Start"}, {"id": "31", "name": "Close", "to": map[string]any{"name": "Done"}}},
+	})
+	srv.handle("POST", "/rest/api/2/issue/OPS-7/transitions", http.StatusNoContent, nil)
+	if err := j.Resolve(ctx, "OPS-7", 0); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if tr := srv.last(t, "POST", "/rest/api/2/issue/OPS-7/transitions").Body["transition"].(map[string]any); tr["id"] != "31" {
+		t.Errorf("Resolve ran transition %v, want the one to Done", tr)
+	}
+}
+
+func TestServiceNowTicketer(t *testing.T) {
+	srv := newStandIn(t)
+	withConfig(t, &Config{Ticket: TicketConfig{Dedupe: DedupeConfig{Enabled: true, MatchSummary: true}, Notes: NoteConfig{Internal: true}}})
+	s := newServiceNowTicketer(ServiceNowConfig{URL: srv.URL, User: "pingo", Password: "pw", AssignmentGroup: "Network",
+		Urgency: map[string]int{StageTunnel: 1}})
+	ctx := context.Background()
+
+	// An instance that ignores sysparm_exclude_reference_link still answers with reference objects
+	srv.handle("POST", "/api/now/table/incident", http.StatusCreated, map[string]any{"result": map[string]any{
+		"sys_id": "abc", "number": "INC001", "urgency": "1",
+		"opened_by": map[string]any{"link": srv.URL + "/api/now/table/sys_user/u1", "value": "u1"},
+	}})
+	id, err := s.Create(ctx, TicketRequest{Site: "hq", Stage: StageTunnel, Summary: "Tunnel down - hq", Fields: TicketFields{Priority: 6}})
+	if err != nil || id != "abc" {
+		t.Fatalf("Create = %q, %v, want abc", id, err)
+	}
+	req := srv.last(t, "POST", "/api/now/table/incident")
+	if b := req.Body; b["correlation_id"] != "pingo-hq" || b["assignment_group"] != "Network" || b["urgency"] != 1.0 {
+		t.Errorf("Create sent %v", b)
+	}
+	if !strings.Contains(req.Query, "sysparm_exclude_reference_link=true") {
+		t.Errorf("Create sent query %q, want reference links excluded", req.Query)
+	}
+
+	srv.handle("PATCH", "/api/now/table/incident/abc", http.StatusOK, map[string]any{"result": map[string]any{}})
+	if err := s.Update(ctx, "abc", TicketRequest{Stage: StageWAN, Summary: "WAN down - hq", Fields: TicketFields{Priority: 6}}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if b := srv.last(t, "PATCH", "/api/now/table/incident/abc").Body; b["urgency"] != nil {
+		t.Errorf("Update sent urgency %v for a stage without one", b["urgency"])
+	}
+	if err := s.AddNote(ctx, "abc", "still down", false); err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+	if b := srv.last(t, "PATCH", "/api/now/table/incident/abc").Body; b["work_notes"] != "still down" {
+		t.Errorf("AddNote sent %v, want a work note", b)
+	}
+
+	srv.handle("GET", "/api/now/table/incident/abc", http.StatusOK, map[string]any{"result": map[string]any{
+		"sys_id": "abc", "active": "false", "state": "6", "number": "INC001",
+		"assignment_group": map[string]any{"link": srv.URL + "/api/now/table/sys_user_group/g1", "value": "g1"},
+	}})
+	if open, status, err := s.IsOpen(ctx, "abc"); err != nil || open || status != "state 6 (INC001)" {
+		t.Errorf("IsOpen of an inactive record = %v, %q, %v, want false and state 6", open, status, err)
+	}
+	if _, _, err := s.IsOpen(ctx, "gone"); !errors.Is(err, ErrTicketNotFound) {
+		t.Errorf("IsOpen of a missing record returned %v, want ErrTicketNotFound", err)
+	}
+
+	srv.handle("GET", "/api/now/table/incident", http.StatusOK, map[string]any{"result": []map[string]any{
+		{"sys_id": "xyz", "short_description": "Tunnel down - hq2"},
+		{"sys_id": "def", "short_description": "Tunnel down - hq (opened by hand)"},
+	}})
+	found, _, err := s.FindOpen(ctx, TicketRequest{Site: "hq", Summary: "Tunnel down - hq"})
+	if err != nil || found != "def" {
+		t.Fatalf("FindOpen = %q, %v, want def", found, err)
+	}
+	q := srv.last(t, "GET", "/api/now/table/incident").Query
+	if !strings.Contains(q, "correlation_id%3Dpingo-hq") || !strings.Contains(q, "short_descriptionSTARTSWITHTunnel+down+-+hq") ||
+		!strings.Contains(q, "sysparm_exclude_reference_link=true") {
+		t.Errorf("FindOpen searched with %q", q)
+	}
+}
+
+func TestWebhookTicketer(t *testing.T) {
+	srv := newStandIn(t)
+	w := newWebhookTicketer(WebhookConfig{URL: srv.URL + "/hook", Token: "tok", Headers: map[string]string{"X-Source": "pingo"}})
+	ctx := context.Background()
+
+	srv.handle("POST", "/hook", http.StatusOK, map[string]any{"ticketId": "T-1", "open": false})
+	id, err := w.Create(ctx, TicketRequest{Site: "hq", Stage: StageTunnel, Summary: "Tunnel down - hq", Fields: TicketFields{Priority: 6}})
+	if err != nil || id != "T-1" {
+		t.Fatalf("Create = %q, %v, want T-1", id, err)
+	}
+	req := srv.last(t, "POST", "/hook")
+	if req.Header.Get("Authorization") != "Bearer tok" || req.Header.Get("X-Source") != "pingo" {
+		t.Errorf("Create sent headers %v", req.Header)
+	}
+	if req.Body["event"] != "create" || req.Body["site"] != "hq" || req.Body["summary"] != "Tunnel down - hq" {
+		t.Errorf("Create sent %v", req.Body)
+	}
+	if req.Body["severity"] != "critical" || req.Body["priority"] != nil {
+		t.Errorf("Create sent severity %v and priority %v, want critical and no Manage priority", req.Body["severity"], req.Body["priority"])
+	}
+	if err := w.Update(ctx, "T-1", TicketRequest{Site: "hq", Stage: StageDegraded, Summary: "Tunnel degraded - hq"}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if b := srv.last(t, "POST", "/hook").Body; b["event"] != "update" || b["severity"] != "warning" {
+		t.Errorf("Update to degraded sent %v, want severity warning", b)
+	}
+	if open, _, err := w.IsOpen(ctx, "T-1"); err != nil || open {
+		t.Errorf("IsOpen = %v, %v, want false as the endpoint answered", open, err)
+	}
+
+	srv.handle("POST", "/hook", http.StatusNoContent, nil)
+	id, err = w.Create(ctx, TicketRequest{Site: "hq"})
+	if err != nil || !strings.HasPrefix(string(id), "hq-") {
+		t.Errorf("Create without a ticket ID in the answer = %q, %v, want an ID made up from the site", id, err)
+	}
+	if open, _, err := w.IsOpen(ctx, id); err != nil || !open {
+		t.Errorf("IsOpen without an answer = %v, %v, want true", open, err)
+	}
+	if err := w.AddNote(ctx, id, "recovered", true); err != nil {
+		t.Fatalf("AddNote: %v", err)
+	}
+	if b := srv.last(t, "POST", "/hook").Body; b["event"] != "note" || b["ticketId"] != string(id) || b["resolution"] != true {
+		t.Errorf("AddNote sent %v", b)
+	}
+
+	srv.handle("POST", "/hook", http.StatusBadGateway, nil)
+	if err := w.Resolve(ctx, id, 0); !isTemporary(err) {
+		t.Errorf("Resolve against a failing endpoint returned %v, want a temporary error", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"pingo/webhook"
+)
+
+// WebhookConfig holds an endpoint that receives ticket events as JSON, for systems pingo has no client for.
+// The endpoint may answer a create with {"ticketId": "..."} and a status check with {"open": false}.
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Token   string            `yaml:"token"`   // Sent as a bearer token if set
+	Headers map[string]string `yaml:"headers"` // Extra headers sent with every event
+	Timeout time.Duration     `yaml:"timeout"`
+}
+
+func (c WebhookConfig) validate(prefix string) error {
+	if c.URL == "" {
+		return fmt.Errorf("%s.url is required", prefix)
+	}
+	return nil
+}
+
+// webhookTicketer posts every ticket operation to a webhook.
+type webhookTicketer struct {
+	client *webhook.Client
+}
+
+func newWebhookTicketer(c WebhookConfig) *webhookTicketer {
+	return &webhookTicketer{client: webhook.NewClient(webhook.Config{URL: c.URL, Token: c.Token, Headers: c.Headers, Timeout: c.Timeout})}
+}
+
+func (w *webhookTicketer) event(kind string, id TicketID, r TicketRequest) webhook.Event {
+	return webhook.Event{
+		Event:       kind,
+		TicketID:    string(id),
+		Site:        r.Site,
+		Stage:       r.Stage,
+		Summary:     r.Summary,
+		Description: r.Description,
+		Severity:    stageSeverity(r.Stage),
+	}
+}
+
+// stageSeverity is the severity of the notification event a stage is opened for. The Manage priority in
+// the ticket fields means nothing outside Manage, so the webhook gets this instead.
+func stageSeverity(stage string) string {
+	switch stage {
+	case StageDegraded:
+		return eventSeverity[EventDegraded]
+	case StageFlapping:
+		return eventSeverity[EventFlapping]
+	case "":
+		return ""
+	}
+	return eventSeverity[EventDown]
+}
+
+// Create sends a create event. If the endpoint doesn't answer with a ticket ID, one is made up from the
+// site and time so later events can still refer to the ticket.
+func (w *webhookTicketer) Create(ctx context.Context, r TicketRequest) (TicketID, error) {
+	res, err := w.client.Send(ctx, w.event(webhook.EventCreate, "", r))
+	if err != nil {
+		return "", err
+	}
+	if res.TicketID == "" {
+		return TicketID(fmt.Sprintf("%s-%d", r.Site, time.Now().Unix())), nil
+	}
+	return TicketID(res.TicketID), nil
+}
+
+// IsOpen sends a status event. The ticket counts as open unless the endpoint answers {"open": false}.
+// The webhook reports no status name.
+func (w *webhookTicketer) IsOpen(ctx context.Context, id TicketID) (bool, string, error) {
+	res, err := w.client.Send(ctx, webhook.Event{Event: webhook.EventStatus, TicketID: string(id)})
+	if err != nil {
+		return false, "", err
+	}
+	return res.Open == nil || *res.Open, "", nil
+}
+
+func (w *webhookTicketer) AddNote(ctx context.Context, id TicketID, text string, resolution bool) error {
+	_, err := w.client.Send(ctx, webhook.Event{Event: webhook.EventNote, TicketID: string(id), Text: text, Resolution: resolution})
+	return err
+}
+
+func (w *webhookTicketer) Update(ctx context.Context, id TicketID, r TicketRequest) error {
+	_, err := w.client.Send(ctx, w.event(webhook.EventUpdate, id, r))
+	return err
+}
+
+func (w *webhookTicketer) Resolve(ctx context.Context, id TicketID, status int) error {
+	_, err := w.client.Send(ctx, webhook.Event{Event: webhook.EventResolve, TicketID: string(id)})
+	return err
+}
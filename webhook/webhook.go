@@ -0,0 +1,85 @@
+// Package webhook posts ticket events as JSON to a URL, for ticketing systems pingo has no client for.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"pingo/internal/httpapi"
+)
+
+// Errors matched by errors.Is against anything the client returns.
+var (
+	ErrNotFound     = httpapi.ErrNotFound
+	ErrUnauthorized = httpapi.ErrUnauthorized
+)
+
+// Event kinds.
+const (
+	EventCreate  = "create"
+	EventStatus  = "status"
+	EventNote    = "note"
+	EventUpdate  = "update"
+	EventResolve = "resolve"
+)
+
+// Config holds the webhook endpoint.
+type Config struct {
+	URL     string
+	Token   string            // Sent as a bearer token if set
+	Headers map[string]string // Extra headers sent with every event
+	Timeout time.Duration     // Per-request timeout, defaults to 30s
+}
+
+// Event is the JSON body of every request. Fields that don't apply to the event are left out.
+type Event struct {
+	Event       string    `json:"event"`
+	TicketID    string    `json:"ticketId,omitempty"`
+	Site        string    `json:"site,omitempty"`
+	Stage       string    `json:"stage,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Severity    string    `json:"severity,omitempty"` // info, warning or critical
+	Text        string    `json:"text,omitempty"`
+	Resolution  bool      `json:"resolution,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// Response is what the endpoint may answer with. Both fields are optional.
+type Response struct {
+	TicketID string `json:"ticketId"` // For create, the ID of the ticket the endpoint opened
+	Open     *bool  `json:"open"`     // For status, whether the ticket is still open
+}
+
+// Client posts events to the webhook. It is safe for concurrent use.
+type Client struct {
+	api *httpapi.Client
+}
+
+// NewClient returns a Client for the endpoint described by c.
+func NewClient(c Config) *Client {
+	return &Client{api: httpapi.New("webhook", c.URL, c.Timeout, func(r *http.Request) {
+		for k, v := range c.Headers {
+			r.Header.Set(k, v)
+		}
+		if c.Token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+	})}
+}
+
+// Send posts e and decodes the endpoint's response. A response that isn't a JSON Response is ignored.
+func (c *Client) Send(ctx context.Context, e Event) (Response, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	var raw []byte
+	var out Response
+	if err := c.api.Do(ctx, http.MethodPost, "", nil, e, &raw); err != nil {
+		return out, err
+	}
+	_ = json.Unmarshal(raw, &out)
+	return out, nil
+}